@@ -16,10 +16,14 @@ import (
 	"github.com/davecgh/go-spew/spew"
 
 	"github.com/seventv/ImageProcessor/src/aws"
+	"github.com/seventv/ImageProcessor/src/cache"
 	"github.com/seventv/ImageProcessor/src/configure"
 	"github.com/seventv/ImageProcessor/src/global"
 	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/seventv/ImageProcessor/src/manifest"
+	"github.com/seventv/ImageProcessor/src/monitoring"
 	"github.com/seventv/ImageProcessor/src/rmq"
+	"github.com/seventv/ImageProcessor/src/scheduler"
 	"github.com/seventv/ImageProcessor/src/task"
 	"github.com/sirupsen/logrus"
 )
@@ -60,6 +64,8 @@ func main() {
 		logrus.Infof("build.User: %s", User)
 	}
 
+	manifest.ProcessorVersion = Version
+
 	logrus.Debug("MaxProcs: ", runtime.GOMAXPROCS(0))
 
 	sig := make(chan os.Signal, 1)
@@ -172,12 +178,47 @@ func main() {
 		cancel()
 
 	} else {
-		ctx.Instances().Rmq = rmq.New(ctx)
 		if ctx.Config().Aws.Region != "" {
 			ctx.Instances().AwsS3 = aws.NewS3(ctx)
 		}
 
-		go task.Listen(ctx)
+		// a bad cache would otherwise only show up as every job failing.
+		if _, err := cache.New(ctx.Config(), ctx.Instances().AwsS3); err != nil {
+			logrus.Fatal("bad cache config: ", err)
+		}
+
+		go monitoring.New(ctx)
+
+		// the scheduler keeps the machine busy, so there are more workers than cores to let small jobs run next to large ones.
+		nWorkers := ctx.Config().Scheduler.Workers
+		if nWorkers <= 0 {
+			nWorkers = runtime.GOMAXPROCS(0) * 2
+		}
+
+		if ctx.Config().Rmq.Prefetch <= 0 {
+			ctx.Config().Rmq.Prefetch = nWorkers
+		}
+
+		reserved := ctx.Config().Scheduler.ReservedWorkers
+		if reserved <= 0 && ctx.Config().Rmq.PriorityQueueName != "" {
+			reserved = nWorkers / 4
+			if reserved < 1 {
+				reserved = 1
+			}
+		}
+
+		workers := task.NewWorkers(nWorkers, reserved, scheduler.New(ctx.Config()))
+
+		// in serve mode rmq is optional so small deployments can run without a broker.
+		if !config.Serve || ctx.Config().Rmq.ServerURL != "" {
+			ctx.Instances().Rmq = rmq.New(ctx)
+
+			go task.Listen(ctx, workers)
+		}
+
+		if config.Serve {
+			go task.Serve(ctx, workers)
+		}
 
 		logrus.Info("running")
 	}
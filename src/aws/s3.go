@@ -6,6 +6,7 @@ import (
 	"io"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -14,6 +15,12 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+var (
+	ErrNoSuchKey      = fmt.Errorf("no such key")
+	ErrUploadFailed   = fmt.Errorf("failed to upload file")
+	ErrDownloadFailed = fmt.Errorf("failed to download file")
+)
+
 var (
 	DefaultCacheControl = aws.String("public, max-age=15552000")
 	AclPublicRead       = aws.String("public-read")
@@ -60,7 +67,7 @@ func (a *AwsS3Instance) UploadFile(ctx context.Context, bucket, key string, data
 		CacheControl: cacheControl,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to upload file, %v", err)
+		return fmt.Errorf("%w, %v", ErrUploadFailed, err)
 	}
 
 	logrus.Debugf("file uploaded to, %s", result.Location)
@@ -73,9 +80,38 @@ func (a *AwsS3Instance) DownloadFile(ctx context.Context, bucket, key string, fi
 		Key:    aws.String(key),
 	})
 	if err != nil {
-		return fmt.Errorf("failed to download file, %v", err)
+		if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound") {
+			return fmt.Errorf("%w: %s %s", ErrNoSuchKey, bucket, key)
+		}
+		return fmt.Errorf("%w, %v", ErrDownloadFailed, err)
 	}
 
 	logrus.Debugf("%d bytes downloaded from %s %s", n, bucket, key)
 	return nil
 }
+
+func (a *AwsS3Instance) ObjectSize(ctx context.Context, bucket, key string) (int64, error) {
+	result, err := a.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound") {
+			return 0, fmt.Errorf("%w: %s %s", ErrNoSuchKey, bucket, key)
+		}
+		return 0, fmt.Errorf("%w, %v", ErrDownloadFailed, err)
+	}
+
+	return aws.Int64Value(result.ContentLength), nil
+}
+
+func (a *AwsS3Instance) HeadBucket(ctx context.Context, bucket string) error {
+	_, err := a.s3.HeadBucketWithContext(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to head bucket, %v", err)
+	}
+
+	return nil
+}
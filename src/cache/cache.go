@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/seventv/ImageProcessor/src/configure"
+	"github.com/seventv/ImageProcessor/src/global"
+	"github.com/seventv/ImageProcessor/src/image"
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/seventv/ImageProcessor/src/manifest"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+var (
+	ErrMiss           = fmt.Errorf("cache miss")
+	ErrUnknownBackend = fmt.Errorf("unknown cache backend")
+	ErrNoS3           = fmt.Errorf("the s3 cache needs aws to be configured")
+	ErrNoBucket       = fmt.Errorf("the s3 cache needs a bucket")
+)
+
+// version is part of every key, bump it whenever the outputs for the same job change so old entries are no longer used.
+const version = 2
+
+const entryName = "entry.json"
+
+type Backend string
+
+const (
+	LocalBackend Backend = "local"
+	S3Backend    Backend = "s3"
+)
+
+// Entry is what is stored next to the outputs of a job.
+type Entry struct {
+	Type  image.ImageType `json:"type"`
+	Files []job.File      `json:"files"`
+	Trim  *image.Rect     `json:"trim,omitempty"`
+	// Source is kept so the manifest of a cached job can be made without probing the input again.
+	Source manifest.Source `json:"source"`
+}
+
+type Cache interface {
+	// Fetch copies the outputs stored under key into dir, it returns ErrMiss when nothing is stored.
+	Fetch(ctx context.Context, key string, dir string) (*Entry, error)
+	// Store copies every file of the entry out of dir and stores them under key.
+	Store(ctx context.Context, key string, entry Entry, dir string) error
+}
+
+// New returns the cache from the config, it is nil when the cache is disabled.
+func New(config *configure.Config, s3 global.AwsS3) (Cache, error) {
+	switch Backend(config.Cache.Backend) {
+	case "":
+		return nil, nil
+	case LocalBackend:
+		return &localCache{dir: config.Cache.Dir}, nil
+	case S3Backend:
+		if s3 == nil {
+			return nil, ErrNoS3
+		}
+		if config.Cache.Bucket == "" {
+			return nil, ErrNoBucket
+		}
+
+		return &s3Cache{
+			s3:     s3,
+			bucket: config.Cache.Bucket,
+			prefix: config.Cache.Prefix,
+		}, nil
+	}
+
+	return nil, ErrUnknownBackend
+}
+
+// Key hashes the source together with everything in the job which changes the outputs.
+// The encode options should already have their defaults so a job relying on the config and one spelling them out share a key.
+func Key(data []byte, j job.Job, encodeOptions job.EncodeOptions) (string, error) {
+	// map keys are sorted when marshalled so the sizes are always in the same order.
+	opts, err := json.Marshal(struct {
+		Version       int                      `json:"version"`
+		AspectRatioXY []int                    `json:"aspect_ratio_xy"`
+		Sizes         map[string]job.ImageSize `json:"sizes"`
+		Settings      uint64                   `json:"settings"`
+		Framing       job.Framing              `json:"framing"`
+		Trim          job.Trim                 `json:"trim"`
+		EncodeOptions job.EncodeOptions        `json:"encode_options"`
+	}{
+		Version:       version,
+		AspectRatioXY: j.AspectRatioXY,
+		Sizes:         j.Sizes,
+		Settings:      j.Settings,
+		Framing:       j.Framing,
+		Trim:          j.Trim,
+		EncodeOptions: encodeOptions,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	hash.Write(data)
+	hash.Write(opts)
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/seventv/ImageProcessor/src/configure"
+	"github.com/seventv/ImageProcessor/src/image"
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Key(t *testing.T) {
+	quality := 80
+	j := job.Job{
+		ID:       "a",
+		Settings: job.AllSettings,
+		Sizes: map[string]job.ImageSize{
+			"1x": {Width: 96, Height: 32},
+			"2x": {Width: 192, Height: 64},
+		},
+	}
+
+	key, err := Key([]byte("source"), j, job.EncodeOptions{})
+	assert.ErrorIs(t, err, nil, "no error when hashing")
+
+	other := j
+	other.ID = "b"
+	other.RawProvider = job.HttpProvider
+	otherKey, _ := Key([]byte("source"), other, job.EncodeOptions{})
+	assert.Equal(t, key, otherKey, "The id and providers do not change the outputs")
+
+	otherKey, _ = Key([]byte("other source"), j, job.EncodeOptions{})
+	assert.NotEqual(t, key, otherKey, "The source changes the key")
+
+	otherKey, _ = Key([]byte("source"), j, job.EncodeOptions{AVIF: job.EncoderOptions{Quality: &quality}})
+	assert.NotEqual(t, key, otherKey, "The encode options change the key")
+
+	other = j
+	other.Settings = job.EnableOutputAnimatedGIF
+	otherKey, _ = Key([]byte("source"), other, job.EncodeOptions{})
+	assert.NotEqual(t, key, otherKey, "The settings change the key")
+}
+
+func Test_LocalCache(t *testing.T) {
+	c := &localCache{dir: path.Join(t.TempDir(), "cache")}
+	ctx := context.Background()
+
+	_, err := c.Fetch(ctx, "key", t.TempDir())
+	assert.True(t, errors.Is(err, ErrMiss), "An empty cache misses")
+
+	src := t.TempDir()
+	assert.ErrorIs(t, os.WriteFile(path.Join(src, "4x.webp"), []byte("webp"), 0600), nil)
+
+	entry := Entry{
+		Type:  image.GIF,
+		Files: []job.File{{Name: "4x.webp", ContentType: "image/webp", Size: 4}},
+		Trim:  &image.Rect{X: 1, Y: 2, Width: 3, Height: 4},
+	}
+	assert.ErrorIs(t, c.Store(ctx, "key", entry, src), nil, "no error when storing")
+	assert.ErrorIs(t, c.Store(ctx, "key", entry, src), nil, "storing the same key twice is fine")
+
+	dst := t.TempDir()
+	fetched, err := c.Fetch(ctx, "key", dst)
+	if !assert.ErrorIs(t, err, nil, "no error when fetching") {
+		return
+	}
+
+	assert.Equal(t, entry, *fetched, "The entry round trips")
+
+	data, _ := os.ReadFile(path.Join(dst, "4x.webp"))
+	assert.Equal(t, "webp", string(data), "The outputs were copied into the folder")
+}
+
+func Test_New(t *testing.T) {
+	config := &configure.Config{}
+	config.Cache.Backend = string(S3Backend)
+
+	_, err := New(config, nil)
+	assert.ErrorIs(t, err, ErrNoS3, "The s3 cache needs aws")
+
+	c, err := New(&configure.Config{}, nil)
+	assert.ErrorIs(t, err, nil, "no error when the cache is disabled")
+	assert.Nil(t, c, "The cache is disabled")
+}
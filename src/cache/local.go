@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+)
+
+type localCache struct {
+	dir string
+}
+
+func copyFile(src string, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(dst, data, 0600)
+}
+
+func (c *localCache) Fetch(ctx context.Context, key string, dir string) (*Entry, error) {
+	data, err := os.ReadFile(path.Join(c.dir, key, entryName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrMiss
+		}
+		return nil, err
+	}
+
+	entry := &Entry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+
+	for _, f := range entry.Files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if err := copyFile(path.Join(c.dir, key, f.Name), path.Join(dir, f.Name)); err != nil {
+			return nil, err
+		}
+	}
+
+	return entry, nil
+}
+
+func (c *localCache) Store(ctx context.Context, key string, entry Entry, dir string) error {
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+
+	// the entry is written to a temporary folder first so a half written entry is never fetched.
+	tmp, err := os.MkdirTemp(c.dir, key+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, f := range entry.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := copyFile(path.Join(dir, f.Name), path.Join(tmp, f.Name)); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path.Join(tmp, entryName), data, 0600); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path.Join(c.dir, key)); err != nil {
+		// another worker stored the same key first, the entries are the same so theirs is kept.
+		if _, statErr := os.Stat(path.Join(c.dir, key, entryName)); statErr == nil {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path"
+
+	Aws "github.com/aws/aws-sdk-go/aws"
+	"github.com/seventv/ImageProcessor/src/aws"
+	"github.com/seventv/ImageProcessor/src/global"
+	"github.com/seventv/ImageProcessor/src/utils"
+)
+
+type s3Cache struct {
+	s3     global.AwsS3
+	bucket string
+	prefix string
+}
+
+func (c *s3Cache) download(ctx context.Context, key string) ([]byte, error) {
+	buf := Aws.NewWriteAtBuffer([]byte{})
+	if err := c.s3.DownloadFile(ctx, c.bucket, key, buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c *s3Cache) Fetch(ctx context.Context, key string, dir string) (*Entry, error) {
+	data, err := c.download(ctx, path.Join(c.prefix, key, entryName))
+	if err != nil {
+		if errors.Is(err, aws.ErrNoSuchKey) {
+			return nil, ErrMiss
+		}
+		return nil, err
+	}
+
+	entry := &Entry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+
+	for _, f := range entry.Files {
+		data, err := c.download(ctx, path.Join(c.prefix, key, f.Name))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.WriteFile(path.Join(dir, f.Name), data, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	return entry, nil
+}
+
+func (c *s3Cache) Store(ctx context.Context, key string, entry Entry, dir string) error {
+	for _, f := range entry.Files {
+		file, err := os.Open(path.Join(dir, f.Name))
+		if err != nil {
+			return err
+		}
+
+		err = c.s3.UploadFile(ctx, c.bucket, path.Join(c.prefix, key, f.Name), file, utils.StringPointer(f.ContentType), aws.AclPrivate, nil)
+		_ = file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// the entry goes last so it is only fetched once every output is there.
+	return c.s3.UploadFile(ctx, c.bucket, path.Join(c.prefix, key, entryName), bytes.NewReader(data), utils.StringPointer("application/json"), aws.AclPrivate, nil)
+}
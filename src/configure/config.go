@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	jsoniter "github.com/json-iterator/go"
+	"github.com/seventv/ImageProcessor/src/job"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -42,6 +43,8 @@ func New() *Config {
 	pflag.String("aspect_ratio", "3:1", "The aspect ratio for inline convert")
 	pflag.StringSlice("sizes", nil, "The sizes to convert the emotes to, name:width:height ie. `4x:384:128`")
 
+	pflag.Bool("serve", false, "Run the http job api")
+
 	pflag.Bool("noheader", false, "Disable the startup header")
 	pflag.Parse()
 	checkErr(config.BindPFlags(pflag.CommandLine))
@@ -99,6 +102,31 @@ type Config struct {
 	AspectRatio string   `json:"aspect_ratio,omitempty" mapstructure:"aspect_ratio,omitempty"`
 	Sizes       []string `json:"sizes,omitempty" mapstructure:"sizes,omitempty"`
 
+	// http job api
+	Serve bool `json:"serve,omitempty" mapstructure:"serve,omitempty"`
+	Api   struct {
+		Bind      string `json:"bind,omitempty" mapstructure:"bind,omitempty"`
+		Retention int    `json:"retention,omitempty" mapstructure:"retention,omitempty"`
+	} `json:"api,omitempty" mapstructure:"api,omitempty"`
+
+	Monitoring struct {
+		Enabled bool   `json:"enabled,omitempty" mapstructure:"enabled,omitempty"`
+		Bind    string `json:"bind,omitempty" mapstructure:"bind,omitempty"`
+	} `json:"monitoring,omitempty" mapstructure:"monitoring,omitempty"`
+
+	Health struct {
+		Bucket       string `json:"bucket,omitempty" mapstructure:"bucket,omitempty"`
+		MinFreeSpace int64  `json:"min_free_space,omitempty" mapstructure:"min_free_space,omitempty"`
+	} `json:"health,omitempty" mapstructure:"health,omitempty"`
+
+	// Cache stores the outputs of every job by the source and options, Backend is local or s3 and the cache is disabled when it is empty.
+	Cache struct {
+		Backend string `json:"backend,omitempty" mapstructure:"backend,omitempty"`
+		Dir     string `json:"dir,omitempty" mapstructure:"dir,omitempty"`
+		Bucket  string `json:"bucket,omitempty" mapstructure:"bucket,omitempty"`
+		Prefix  string `json:"prefix,omitempty" mapstructure:"prefix,omitempty"`
+	} `json:"cache,omitempty" mapstructure:"cache,omitempty"`
+
 	// Aws
 	Aws struct {
 		AccessToken string `json:"access_token,omitempty" mapstructure:"access_token,omitempty"`
@@ -112,10 +140,45 @@ type Config struct {
 		JobQueueName    string `json:"job_queue_name,omitempty" mapstructure:"job_queue_name,omitempty"`
 		ResultQueueName string `json:"result_queue_name,omitempty" mapstructure:"result_queue_name,omitempty"`
 		UpdateQueueName string `json:"update_queue_name,omitempty" mapstructure:"update_queue_name,omitempty"`
+		// PriorityQueueName is an optional second job queue, every job consumed from it is treated as priority 1 or above.
+		PriorityQueueName string `json:"priority_queue_name,omitempty" mapstructure:"priority_queue_name,omitempty"`
+		// ControlExchangeName is the fanout exchange control messages are published to.
+		ControlExchangeName string `json:"control_exchange_name,omitempty" mapstructure:"control_exchange_name,omitempty"`
+		// DeadLetterQueueName receives jobs that failed permanently or ran out of attempts.
+		DeadLetterQueueName string `json:"dead_letter_queue_name,omitempty" mapstructure:"dead_letter_queue_name,omitempty"`
+		// MaxAttempts is how many times a job is tried before it is dead lettered.
+		MaxAttempts int `json:"max_attempts,omitempty" mapstructure:"max_attempts,omitempty"`
+		// RetryDelay is the delay in seconds before the first retry, it doubles with every attempt up to MaxRetryDelay.
+		RetryDelay    int `json:"retry_delay,omitempty" mapstructure:"retry_delay,omitempty"`
+		MaxRetryDelay int `json:"max_retry_delay,omitempty" mapstructure:"max_retry_delay,omitempty"`
+		// Prefetch is how many unacked jobs rmq hands this processor at once, it defaults to the number of workers.
+		Prefetch int `json:"prefetch,omitempty" mapstructure:"prefetch,omitempty"`
 	} `json:"rmq,omitempty" mapstructure:"rmq,omitempty"`
 
+	Scheduler struct {
+		// Workers is how many jobs can be in flight at once, admission to the heavy stages is decided by the budgets.
+		Workers int `json:"workers,omitempty" mapstructure:"workers,omitempty"`
+		// ReservedWorkers are only used by jobs with a priority above 0.
+		ReservedWorkers int `json:"reserved_workers,omitempty" mapstructure:"reserved_workers,omitempty"`
+		// CPUBudget is how many pixels, summed over every frame and output format, are encoded at once.
+		CPUBudget int64 `json:"cpu_budget,omitempty" mapstructure:"cpu_budget,omitempty"`
+		// MemoryBudget is how many bytes of decoded frames are held at once.
+		MemoryBudget int64 `json:"memory_budget,omitempty" mapstructure:"memory_budget,omitempty"`
+	} `json:"scheduler,omitempty" mapstructure:"scheduler,omitempty"`
+
+	Http struct {
+		MaxSize      int64 `json:"max_size,omitempty" mapstructure:"max_size,omitempty"`
+		MaxRedirects int   `json:"max_redirects,omitempty" mapstructure:"max_redirects,omitempty"`
+		Timeout      int   `json:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+	} `json:"http,omitempty" mapstructure:"http,omitempty"`
+
 	WorkingDir      string `json:"working_dir,omitempty" mapstructure:"working_dir,omitempty"`
 	MaxTaskDuration int    `json:"max_task_duration,omitempty" mapstructure:"max_task_duration,omitempty"`
 	Av1Decoder      string `json:"av1_decoder,omitempty" mapstructure:"av1_decoder,omitempty"`
 	Av1Encoder      string `json:"av1_encoder,omitempty" mapstructure:"av1_encoder,omitempty"`
+
+	// Encode is the default for any encode option a job leaves out.
+	Encode job.EncodeOptions `json:"encode,omitempty" mapstructure:"encode,omitempty"`
+	// Limits bound the inputs of every job, jobs can lower them but not raise them.
+	Limits job.Limits `json:"limits,omitempty" mapstructure:"limits,omitempty"`
 }
@@ -12,9 +12,41 @@ import (
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/seventv/ImageProcessor/src/configure"
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/seventv/ImageProcessor/src/monitoring"
 )
 
-func Encode(ctx context.Context, config *configure.Config, name string, outName string, dir string, frames []string, delays []int) error {
+// quantizers maps a quality from 0 to 100 onto a range of avifenc quantizers from 63 to 0, without a quality the range is 10 to 20.
+func quantizers(quality *int) (int, int) {
+	if quality == nil {
+		return 10, 20
+	}
+
+	q := (100 - *quality) * 63 / 100
+
+	min := q - 5
+	if min < 0 {
+		min = 0
+	}
+
+	max := q + 5
+	if max > 63 {
+		max = 63
+	}
+
+	return min, max
+}
+
+// Jobs is the --jobs flag of the libavif tools for the threads a job may use, 0 leaves it to libavif.
+func Jobs(threads int) string {
+	if threads <= 0 {
+		return "all"
+	}
+
+	return strconv.Itoa(threads)
+}
+
+func Encode(ctx context.Context, config *configure.Config, name string, outName string, dir string, frames []string, delays []int, threads int, opts job.EncoderOptions) error {
 	// ffmpeg -y -i input.gif -vsync 1 -pix_fmt yuva444p -f yuv4mpegpipe -strict -1 - | avifenc --stdin output.avif
 	avifFile := path.Join(dir, fmt.Sprintf("%s.avif", outName))
 	var ffmpegCmd *exec.Cmd
@@ -22,6 +54,7 @@ func Encode(ctx context.Context, config *configure.Config, name string, outName
 		ffmpegCmd = exec.CommandContext(
 			ctx,
 			"ffmpeg",
+			"-threads", strconv.Itoa(threads),
 			"-i", path.Join(dir, "frames", name, frames[0]),
 			"-vsync", "0",
 			"-f", "yuv4mpegpipe",
@@ -37,6 +70,7 @@ func Encode(ctx context.Context, config *configure.Config, name string, outName
 		ffmpegCmd = exec.CommandContext(
 			ctx,
 			"ffmpeg",
+			"-threads", strconv.Itoa(threads),
 			"-i", fmt.Sprintf("concat:%s", strings.Join(newFrames, "|")),
 			"-vsync", "0",
 			"-f", "yuv4mpegpipe",
@@ -49,7 +83,7 @@ func Encode(ctx context.Context, config *configure.Config, name string, outName
 	durations := make([]string, len(delays))
 	for i, v := range delays {
 		if v == 0 {
-			v = 1
+			v = 10
 		}
 		durations[i] = strconv.Itoa(v)
 	}
@@ -59,22 +93,44 @@ func Encode(ctx context.Context, config *configure.Config, name string, outName
 		encoder = "rav1e"
 	}
 
-	avifEncCmd := exec.CommandContext(
-		ctx,
-		"avifenc",
+	keyframe := len(frames) / 4
+	if opts.KeyframeInterval != nil {
+		keyframe = *opts.KeyframeInterval
+	}
+
+	speed := 3
+	if opts.Effort != nil {
+		speed = 10 - *opts.Effort
+	}
+
+	args := []string{
 		"--stdin-durations", strconv.Itoa(len(delays)), strings.Join(durations, ","),
-		"--keyframe", fmt.Sprint(len(frames)/4),
-		"--speed", "3",
-		"--timescale", "100",
-		"--min", "10",
-		"--max", "20",
-		"--minalpha", "10",
-		"--maxalpha", "20",
-		"--jobs", "all",
+		"--keyframe", strconv.Itoa(keyframe),
+		"--speed", strconv.Itoa(speed),
+		"--timescale", "1000",
+	}
+
+	if opts.Lossless != nil && *opts.Lossless {
+		args = append(args, "--lossless")
+	} else {
+		min, max := quantizers(opts.Quality)
+		minAlpha, maxAlpha := quantizers(opts.AlphaQuality)
+		args = append(args,
+			"--min", strconv.Itoa(min),
+			"--max", strconv.Itoa(max),
+			"--minalpha", strconv.Itoa(minAlpha),
+			"--maxalpha", strconv.Itoa(maxAlpha),
+		)
+	}
+
+	args = append(args,
+		"--jobs", Jobs(threads),
 		"--codec", encoder,
 		"--stdin", avifFile,
 	)
 
+	avifEncCmd := exec.CommandContext(ctx, "avifenc", args...)
+
 	r, w := io.Pipe()
 	ffmpegCmd.Stdout = w
 	avifEncCmd.Stdin = r
@@ -88,29 +144,50 @@ func Encode(ctx context.Context, config *configure.Config, name string, outName
 
 	err := avifEncCmd.Start()
 	if err != nil {
+		monitoring.ExecFailed("avifenc")
 		return fmt.Errorf("avifenc failed: %s : %s : %s", err.Error(), ffmpegOut.String(), avifEncOut.String())
 	}
 
 	err = ffmpegCmd.Start()
 	if err != nil {
+		monitoring.ExecFailed("ffmpeg")
 		return fmt.Errorf("ffmpeg failed: %s : %s : %s", err.Error(), ffmpegOut.String(), avifEncOut.String())
 	}
 
-	done := make(chan error)
+	type result struct {
+		tool string
+		err  error
+	}
+
+	done := make(chan result, 2)
 
 	go func() {
-		done <- ffmpegCmd.Wait()
+		done <- result{"ffmpeg", ffmpegCmd.Wait()}
 		w.Close()
 	}()
 
 	go func() {
-		done <- avifEncCmd.Wait()
+		done <- result{"avifenc", avifEncCmd.Wait()}
 		r.Close()
 	}()
 
-	err = multierror.Append(<-done, <-done).ErrorOrNil()
-	if err != nil {
-		err = fmt.Errorf("avifenc failed: %s : %s : %s", err.Error(), ffmpegOut.String(), avifEncOut.String())
+	// the first tool to fail is the cause, the other one usually only fails because its end of the pipe was closed.
+	failed := ""
+	for i := 0; i < 2; i++ {
+		res := <-done
+		if res.err == nil {
+			continue
+		}
+
+		if failed == "" {
+			failed = res.tool
+		}
+		err = multierror.Append(err, fmt.Errorf("%s: %w", res.tool, res.err))
+	}
+
+	if failed != "" {
+		monitoring.ExecFailed(failed)
+		err = fmt.Errorf("%s failed: %s : %s : %s", failed, err.Error(), ffmpegOut.String(), avifEncOut.String())
 	}
 
 	return err
@@ -1,5 +1,9 @@
 package avif
 
+import (
+	"github.com/seventv/ImageProcessor/src/containers/bmff"
+)
+
 /*
 From: Wan-Teh Chang <wtc@google.com>
 Date: Wed, 9 Feb 2022 10:40:45 -0800
@@ -39,19 +43,12 @@ check for the "avif" branch, which is used for AVIF still images?
 Wan-Teh
 */
 
+// Test checks the major and compatible brands of the ftyp box, which addresses all 3 of the issues above.
 func Test(data []byte) bool {
-	if len(data) < 12 {
+	ftyp, ok := bmff.ParseFtyp(data)
+	if !ok {
 		return false
 	}
 
-	return data[0] == 0x00 &&
-		data[1] == 0x00 &&
-		data[4] == 'f' &&
-		data[5] == 't' &&
-		data[6] == 'y' &&
-		data[7] == 'p' &&
-		data[8] == 'a' &&
-		data[9] == 'v' &&
-		data[10] == 'i' &&
-		(data[11] == 's' || data[11] == 'f')
+	return ftyp.HasBrand(bmff.AvifBrands...)
 }
@@ -0,0 +1,55 @@
+package avif
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/seventv/ImageProcessor/src/containers/bmff"
+)
+
+var ErrNoSize = fmt.Errorf("avif has no size")
+
+type Info struct {
+	Width  int
+	Height int
+	// Frames is the number of samples of an image sequence, a still image has 1.
+	Frames int
+}
+
+// Probe reads the size and frame count from the boxes of the container so no decoder is needed before the limits are checked.
+// Many ffmpeg builds cannot demux avif so it is not probed with ffprobe like the other bmff inputs.
+func Probe(data []byte) (Info, error) {
+	info := Info{Frames: 1}
+
+	// an image can have several spatial extents, for its alpha or thumbnails, the primary image is the largest of them.
+	if ipco, ok := bmff.Find(data, "meta", "iprp", "ipco"); ok {
+		bmff.Children(ipco, func(boxType string, body []byte) bool {
+			if boxType == "ispe" && len(body) >= 12 {
+				width := int(binary.BigEndian.Uint32(body[4:8]))
+				height := int(binary.BigEndian.Uint32(body[8:12]))
+				if width*height > info.Width*info.Height {
+					info.Width, info.Height = width, height
+				}
+			}
+			return true
+		})
+	}
+
+	// sequences describe their frames in the track, the size is 16.16 fixed point at the end of the track header.
+	if tkhd, ok := bmff.Find(data, "moov", "trak", "tkhd"); ok && len(tkhd) >= 8 && info.Width == 0 {
+		info.Width = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) >> 16)
+		info.Height = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) >> 16)
+	}
+
+	if stsz, ok := bmff.Find(data, "moov", "trak", "mdia", "minf", "stbl", "stsz"); ok && len(stsz) >= 12 {
+		if frames := int(binary.BigEndian.Uint32(stsz[8:12])); frames > 0 {
+			info.Frames = frames
+		}
+	}
+
+	if info.Width == 0 || info.Height == 0 {
+		return Info{}, ErrNoSize
+	}
+
+	return info, nil
+}
@@ -0,0 +1,59 @@
+package avif
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func box(boxType string, body ...[]byte) []byte {
+	out := make([]byte, 8)
+	for _, b := range body {
+		out = append(out, b...)
+	}
+	binary.BigEndian.PutUint32(out[0:4], uint32(len(out)))
+	copy(out[4:8], boxType)
+	return out
+}
+
+func join(boxes ...[]byte) []byte {
+	out := []byte{}
+	for _, b := range boxes {
+		out = append(out, b...)
+	}
+	return out
+}
+
+func u32(v ...uint32) []byte {
+	out := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.BigEndian.PutUint32(out[i*4:], x)
+	}
+	return out
+}
+
+func Test_Probe(t *testing.T) {
+	ftyp := box("ftyp", []byte("avif"), u32(0), []byte("mif1"))
+
+	still := join(ftyp, box("meta", u32(0), box("hdlr", u32(0, 0)), box("iprp", box("ipco",
+		box("ispe", u32(0, 64, 32)),
+		box("ispe", u32(0, 128, 96)),
+	))))
+
+	info, err := Probe(still)
+	assert.ErrorIs(t, err, nil, "no error when probing a still image")
+	assert.Equal(t, Info{Width: 128, Height: 96, Frames: 1}, info, "The largest spatial extent is the image")
+
+	sequence := join(ftyp, box("moov", box("trak",
+		box("tkhd", u32(0, 0, 0, 1, 0, 0), u32(200<<16, 100<<16)),
+		box("mdia", box("minf", box("stbl", box("stsz", u32(0, 0, 12))))),
+	)))
+
+	info, err = Probe(sequence)
+	assert.ErrorIs(t, err, nil, "no error when probing a sequence")
+	assert.Equal(t, Info{Width: 200, Height: 100, Frames: 12}, info, "The sequence is read from its track")
+
+	_, err = Probe(ftyp)
+	assert.ErrorIs(t, err, ErrNoSize, "An avif without a size is rejected")
+}
@@ -0,0 +1,48 @@
+package bmff
+
+// fullBoxHeaders are the boxes which hold a version and flags before their children.
+var fullBoxHeaders = map[string]int{
+	"meta": 4,
+}
+
+// Children calls fn with the type and body of every box in data, it stops early when fn returns false.
+// ok is false when a box does not fit in the data.
+func Children(data []byte, fn func(boxType string, body []byte) bool) bool {
+	for len(data) > 0 {
+		boxType, headerSize, size, ok := Box(data)
+		if !ok || size > uint64(len(data)) {
+			return false
+		}
+
+		if !fn(boxType, data[headerSize:size]) {
+			return true
+		}
+
+		data = data[size:]
+	}
+
+	return true
+}
+
+// Find returns the body of the first box at the path, each box of the path is looked up in the body of the one before.
+func Find(data []byte, path ...string) ([]byte, bool) {
+	for _, want := range path {
+		var found []byte
+		Children(data, func(boxType string, body []byte) bool {
+			if boxType != want {
+				return true
+			}
+
+			found = body
+			return false
+		})
+
+		if found == nil || len(found) < fullBoxHeaders[want] {
+			return nil, false
+		}
+
+		data = found[fullBoxHeaders[want]:]
+	}
+
+	return data, true
+}
@@ -0,0 +1,116 @@
+package bmff
+
+import (
+	"encoding/binary"
+)
+
+// ISO-BMFF brands grouped by the container we route them to.
+// https://mp4ra.org/#/brands
+var (
+	AvifBrands = []string{"avif", "avis"}
+	HeifBrands = []string{"heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1"}
+	QtBrands   = []string{"qt  "}
+	Mp4Brands  = []string{"isom", "iso2", "iso3", "iso4", "iso5", "iso6", "mp41", "mp42", "mp71", "avc1", "MSNV", "dash", "M4V ", "f4v "}
+)
+
+// boxes which can start a quicktime file that was written without an ftyp box.
+var qtLeadingBoxes = []string{"moov", "mdat", "wide", "free", "skip", "pnot"}
+
+type Ftyp struct {
+	MajorBrand       string
+	MinorVersion     uint32
+	CompatibleBrands []string
+}
+
+// HasBrand reports if any of the brands is the major brand or one of the compatible brands.
+func (f Ftyp) HasBrand(brands ...string) bool {
+	for _, b := range brands {
+		if f.MajorBrand == b {
+			return true
+		}
+		for _, c := range f.CompatibleBrands {
+			if c == b {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Box reads the header of the box at the start of data.
+// It returns the box type, the size of the header and the size of the whole box, ok is false when data does not start with a valid box header.
+func Box(data []byte) (boxType string, headerSize int, size uint64, ok bool) {
+	if len(data) < 8 {
+		return "", 0, 0, false
+	}
+
+	size = uint64(binary.BigEndian.Uint32(data[0:4]))
+	boxType = string(data[4:8])
+	headerSize = 8
+
+	switch size {
+	case 0:
+		// the box extends to the end of the file.
+		size = uint64(len(data))
+	case 1:
+		if len(data) < 16 {
+			return "", 0, 0, false
+		}
+		size = binary.BigEndian.Uint64(data[8:16])
+		headerSize = 16
+	}
+
+	if size < uint64(headerSize) {
+		return "", 0, 0, false
+	}
+
+	for _, c := range []byte(boxType) {
+		if c < 0x20 || c > 0x7e {
+			return "", 0, 0, false
+		}
+	}
+
+	return boxType, headerSize, size, true
+}
+
+// ParseFtyp parses the ftyp box which must be the first box in the data.
+func ParseFtyp(data []byte) (Ftyp, bool) {
+	boxType, headerSize, size, ok := Box(data)
+	if !ok || boxType != "ftyp" {
+		return Ftyp{}, false
+	}
+
+	// the box must at least hold the major brand and minor version and it must fit in the data we were given.
+	if size < uint64(headerSize+8) || size > uint64(len(data)) {
+		return Ftyp{}, false
+	}
+
+	body := data[headerSize:size]
+	f := Ftyp{
+		MajorBrand:   string(body[0:4]),
+		MinorVersion: binary.BigEndian.Uint32(body[4:8]),
+	}
+
+	for i := 8; i+4 <= len(body); i += 4 {
+		f.CompatibleBrands = append(f.CompatibleBrands, string(body[i:i+4]))
+	}
+
+	return f, true
+}
+
+// IsLegacyQt reports if the data looks like a quicktime file which was written without an ftyp box.
+func IsLegacyQt(data []byte) bool {
+	boxType, _, _, ok := Box(data)
+	if !ok {
+		return false
+	}
+
+	for _, v := range qtLeadingBoxes {
+		if boxType == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,97 @@
+package bmff
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func Test_ParseFtyp(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		ok         bool
+		major      string
+		compatible []string
+	}{
+		{
+			name:       "avifenc still",
+			data:       mustHex("00000020667479706176696600000000617669666d6966316d6961664d413142"),
+			ok:         true,
+			major:      "avif",
+			compatible: []string{"avif", "mif1", "miaf", "MA1B"},
+		},
+		{
+			name:       "64 bit size",
+			data:       mustHex("0000000166747970000000000000001c61766973000000006d696631"),
+			ok:         true,
+			major:      "avis",
+			compatible: []string{"mif1"},
+		},
+		{
+			name:       "size extends to the end",
+			data:       mustHex("0000000066747970717420200000000071742020"),
+			ok:         true,
+			major:      "qt  ",
+			compatible: []string{"qt  "},
+		},
+		{
+			name: "size larger than the data",
+			data: mustHex("000000ff6674797061766966000000006d696631"),
+		},
+		{
+			name: "size smaller than the header",
+			data: mustHex("000000046674797061766966000000006d696631"),
+		},
+		{
+			name: "not an ftyp box",
+			data: mustHex("000000086d6f6f7600000000"),
+		},
+		{
+			name: "truncated",
+			data: mustHex("00000020667479"),
+		},
+		{
+			name: "binary box type",
+			data: mustHex("0000001000010203000000000000000000"),
+		},
+	}
+
+	for _, test := range tests {
+		ftyp, ok := ParseFtyp(test.data)
+		assert.Equal(t, test.ok, ok, test.name)
+		if !test.ok {
+			continue
+		}
+
+		assert.Equal(t, test.major, ftyp.MajorBrand, test.name)
+		assert.Equal(t, test.compatible, ftyp.CompatibleBrands, test.name)
+	}
+}
+
+func Test_HasBrand(t *testing.T) {
+	ftyp := Ftyp{
+		MajorBrand:       "mif1",
+		CompatibleBrands: []string{"avif", "miaf"},
+	}
+
+	assert.True(t, ftyp.HasBrand("mif1"), "The major brand is checked")
+	assert.True(t, ftyp.HasBrand(AvifBrands...), "The compatible brands are checked")
+	assert.False(t, ftyp.HasBrand(QtBrands...), "Missing brands are not found")
+}
+
+func Test_IsLegacyQt(t *testing.T) {
+	assert.True(t, IsLegacyQt(mustHex("0000000877696465000bc7fe6d646174")), "wide then mdat")
+	assert.True(t, IsLegacyQt(mustHex("0000006c6d6f6f76")), "moov first")
+	assert.False(t, IsLegacyQt(mustHex("0000001c6674797061766966")), "ftyp first")
+	assert.False(t, IsLegacyQt([]byte("RIFF")), "too short")
+}
@@ -0,0 +1,91 @@
+package containers
+
+import (
+	"os"
+	"path"
+
+	"github.com/seventv/ImageProcessor/src/job"
+)
+
+func fileSize(file string) (int64, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+// searchQuality encodes the file with the options and when it is larger than maxBytes searches for the highest quality that fits.
+// A lossless output is only made lossy to fit when the options did not ask for lossless.
+// It returns the quality the file was finally encoded at, which is nil when the quality was never set.
+func searchQuality(file string, maxBytes int64, opts job.EncoderOptions, encode func(opts job.EncoderOptions) error) (*int, error) {
+	if err := encode(opts); err != nil {
+		return nil, err
+	}
+
+	if maxBytes <= 0 {
+		return opts.Quality, nil
+	}
+
+	size, err := fileSize(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if size <= maxBytes {
+		return opts.Quality, nil
+	}
+
+	if opts.Lossless != nil && *opts.Lossless {
+		return nil, &job.BudgetError{Name: path.Base(file), MaxBytes: maxBytes, Size: size}
+	}
+
+	lossy := false
+	opts.Lossless = &lossy
+
+	lo, hi := 0, 100
+	if opts.Quality != nil {
+		hi = *opts.Quality - 1
+	}
+
+	best := -1
+	last := -1
+	smallest := size
+	for lo <= hi {
+		q := (lo + hi) / 2
+		opts.Quality = &q
+		if err := encode(opts); err != nil {
+			return nil, err
+		}
+		last = q
+
+		if size, err = fileSize(file); err != nil {
+			return nil, err
+		}
+
+		if size < smallest {
+			smallest = size
+		}
+
+		if size <= maxBytes {
+			best = q
+			lo = q + 1
+		} else {
+			hi = q - 1
+		}
+	}
+
+	if best == -1 {
+		return nil, &job.BudgetError{Name: path.Base(file), MaxBytes: maxBytes, Size: smallest}
+	}
+
+	if last != best {
+		opts.Quality = &best
+		if err := encode(opts); err != nil {
+			return nil, err
+		}
+	}
+
+	return &best, nil
+}
@@ -1,12 +1,12 @@
 package containers
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io/fs"
-	"math"
 	"os"
 	"os/exec"
 	"path"
@@ -17,7 +17,6 @@ import (
 	"sync"
 	"time"
 
-	nGif "image/gif"
 	nPng "image/png"
 
 	"github.com/hashicorp/go-multierror"
@@ -26,7 +25,9 @@ import (
 	"github.com/seventv/ImageProcessor/src/containers/avif"
 	"github.com/seventv/ImageProcessor/src/containers/flv"
 	"github.com/seventv/ImageProcessor/src/containers/gif"
+	"github.com/seventv/ImageProcessor/src/containers/heif"
 	"github.com/seventv/ImageProcessor/src/containers/jpeg"
+	"github.com/seventv/ImageProcessor/src/containers/jxl"
 	"github.com/seventv/ImageProcessor/src/containers/mov"
 	"github.com/seventv/ImageProcessor/src/containers/mp4"
 	"github.com/seventv/ImageProcessor/src/containers/png"
@@ -35,6 +36,7 @@ import (
 	"github.com/seventv/ImageProcessor/src/containers/webp"
 	"github.com/seventv/ImageProcessor/src/image"
 	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/seventv/ImageProcessor/src/monitoring"
 	"github.com/seventv/ImageProcessor/src/utils"
 )
 
@@ -47,16 +49,19 @@ var (
 
 var (
 	avifDumpRe = regexp.MustCompile(`\d+\s+(\d+)\.\d+`)
-	webpMuxRe  = regexp.MustCompile(`\s+\d+:\s+\d+\s+\d+\s+\w+\s+\d+\s+\d+\s+(\d+)\s+\w+\s+\w+\s+\d+\s+\s+\w+`)
 )
 
 func ToType(data []byte) (image.ImageType, error) {
 	if avi.Test(data) {
 		return image.AVI, nil
+	} else if avif.Test(data) {
+		return image.AVIF, nil
 	} else if flv.Test(data) {
 		return image.FLV, nil
 	} else if gif.Test(data) {
 		return image.GIF, nil
+	} else if heif.Test(data) {
+		return image.HEIF, nil
 	} else if jpeg.Test(data) {
 		return image.JPEG, nil
 	} else if mp4.Test(data) {
@@ -71,65 +76,165 @@ func ToType(data []byte) (image.ImageType, error) {
 		return image.WEBP, nil
 	} else if mov.Test(data) {
 		return image.MOV, nil
-	} else if avif.Test(data) { // do this test last because its very loose
-		return image.AVIF, nil
 	}
 
 	return "", ErrUnknownFormat
 }
 
-func ProcessStage1(ctx context.Context, config *configure.Config, file string, imgType image.ImageType, aspectRatioXY [2]int) (*image.Image, error) {
+// Admit is called once the input has been probed and blocks until the job may start decoding and encoding.
+// It returns how many threads the job may use, 0 leaves it to each tool.
+type Admit func(width int, height int, frames int) (int, error)
+
+func ProcessStage1(ctx context.Context, config *configure.Config, file string, imgType image.ImageType, aspectRatioXY [2]int, framing job.Framing, trim job.Trim, limits job.Limits, admit Admit) (*image.Image, error) {
+	frm, err := parseFraming(framing)
+	if err != nil {
+		return nil, err
+	}
+
 	// we need to get infomation about frames for a few types.
 	delay := []int{}
 	frameCount := -1
 
+	var apng *png.Animation
+
+	// the size of the input as far as the probe can tell, it decides the cost of the job.
+	width, height, frames := 0, 0, 1
+
+	// every input is probed and checked against the limits before any frames are written to disk.
 	switch imgType {
 	case image.GIF:
-		// golang
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read file failed: %s", err.Error())
+		}
+
+		info, err := gif.Probe(data)
+		if err != nil {
+			return nil, err
+		}
+
+		frameCount = len(info.Frames)
+		delay = make([]int, frameCount)
+		for i, f := range info.Frames {
+			delay[i] = f.Duration
+		}
+
+		width, height, frames = info.Width, info.Height, frameCount
+
+		if err := limits.Check(info.Width, info.Height, frameCount, sum(delay)); err != nil {
+			return nil, err
+		}
+
 		out, err := exec.CommandContext(ctx, "gifsicle", "-U", file, "-o", file).CombinedOutput()
 		if err != nil {
+			monitoring.ExecFailed("gifsicle")
 			return nil, fmt.Errorf("gifsicle failed: %s : %s", err.Error(), out)
 		}
-
-		f, err := os.OpenFile(file, os.O_RDONLY, 0600)
+	case image.WEBP:
+		data, err := os.ReadFile(file)
 		if err != nil {
 			return nil, fmt.Errorf("read file failed: %s", err.Error())
 		}
-		defer f.Close()
 
-		decGIF, err := nGif.DecodeAll(f)
+		info, err := webp.Probe(data)
 		if err != nil {
 			return nil, err
 		}
 
-		delay = decGIF.Delay
-		frameCount = len(delay)
-	case image.WEBP:
-		// webpmux -info
-		// avifdec -i
-		data, err := exec.CommandContext(ctx, "webpmux", "-info", file).CombinedOutput()
+		frameCount = len(info.Frames)
+		delay = make([]int, frameCount)
+		for i, f := range info.Frames {
+			delay[i] = f.Duration
+		}
+
+		width, height, frames = info.Width, info.Height, frameCount
+
+		if err := limits.Check(info.Width, info.Height, frameCount, sum(delay)); err != nil {
+			return nil, err
+		}
+	case image.PNG:
+		data, err := os.ReadFile(file)
 		if err != nil {
-			return nil, fmt.Errorf("webpmux failed: %s : %s", err.Error(), data)
+			return nil, fmt.Errorf("read file failed: %s", err.Error())
 		}
 
-		matches := webpMuxRe.FindAllStringSubmatch(utils.B2S(data), -1)
-		if len(matches) == 0 {
-			// this is a static webp only 1 frame
-			frameCount = 1
-			delay = make([]int, 1)
-		} else {
-			frameCount = len(matches)
+		apng, err = png.ParseAnimation(data)
+		if err == nil {
+			frameCount = len(apng.Frames)
 			delay = make([]int, frameCount)
-			for i, m := range matches {
-				delay[i], _ = strconv.Atoi(m[1])
-				delay[i] /= 10
+			for i, f := range apng.Frames {
+				delay[i] = f.Duration
+			}
+
+			width, height, frames = apng.Width, apng.Height, frameCount
+
+			if err := limits.Check(apng.Width, apng.Height, frameCount, sum(delay)); err != nil {
+				return nil, err
 			}
+		} else if err != png.ErrNotAnimated && png.IsAnimated(data) {
+			return nil, err
+		} else {
+			// a static png with a damaged or unknown chunk is still left to ffmpeg, which is more forgiving.
+			cfg, err := nPng.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+
+			width, height = cfg.Width, cfg.Height
+
+			if err := limits.Check(cfg.Width, cfg.Height, 1, 0); err != nil {
+				return nil, err
+			}
+		}
+	case image.HEIF:
+		if width, err = vipsHeader(ctx, file, "width"); err != nil {
+			return nil, err
+		}
+
+		if height, err = vipsHeader(ctx, file, "height"); err != nil {
+			return nil, err
+		}
+
+		if err := limits.Check(width, height, 1, 0); err != nil {
+			return nil, err
+		}
+	case image.AVIF:
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read file failed: %s", err.Error())
+		}
+
+		info, err := avif.Probe(data)
+		if err != nil {
+			return nil, err
+		}
+
+		width, height, frames = info.Width, info.Height, info.Frames
+
+		// the duration is only known once avifdump has run, it is checked then.
+		if err := limits.Check(info.Width, info.Height, info.Frames, 0); err != nil {
+			return nil, err
+		}
+	case image.AVI, image.FLV, image.JPEG, image.MP4, image.TIFF, image.WEBM, image.MOV:
+		info, err := probeInput(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+
+		width, height, frames = info.width, info.height, info.frames
+
+		if err := limits.Check(info.width, info.height, info.frames, info.duration); err != nil {
+			return nil, err
 		}
-	case image.AVI, image.FLV, image.JPEG, image.MP4, image.PNG, image.TIFF, image.WEBM, image.AVIF, image.MOV:
 	default:
 		return nil, ErrUnknownFormat
 	}
 
+	threads, err := admit(width, height, frames)
+	if err != nil {
+		return nil, err
+	}
+
 	dir := path.Dir(file)
 	frameDir := path.Join(dir, "frames")
 	if err := os.MkdirAll(frameDir, 0700); err != nil {
@@ -139,8 +244,25 @@ func ProcessStage1(ctx context.Context, config *configure.Config, file string, i
 	// this will get all the frames.
 	switch imgType {
 	case image.AVI, image.FLV, image.GIF, image.JPEG, image.MP4, image.TIFF, image.WEBM, image.PNG, image.MOV:
+		if apng != nil {
+			// we composite apng frames ourselves so the blend and dispose ops match the timings we parsed.
+			if err := apng.Dump(fmt.Sprintf("%s/%s", frameDir, "dump_%04d.png")); err != nil {
+				return nil, fmt.Errorf("apng dump failed: %s", err.Error())
+			}
+			break
+		}
+
 		// ffmpeg
-		if out, err := exec.CommandContext(ctx, "ffmpeg", "-i", file, "-vsync", "0", "-f", "image2", "-start_number", "0", fmt.Sprintf("%s/%s", frameDir, "dump_%04d.png")).CombinedOutput(); err != nil {
+		// ffmpeg treats 0 threads as automatic.
+		args := []string{"-threads", strconv.Itoa(threads), "-i", file, "-vsync", "0"}
+		if limits.MaxFrames > 0 {
+			// packet counts are not always the frame count so ffmpeg is also stopped one frame past the limit.
+			args = append(args, "-frames:v", strconv.FormatInt(limits.MaxFrames+1, 10))
+		}
+		args = append(args, "-f", "image2", "-start_number", "0", fmt.Sprintf("%s/%s", frameDir, "dump_%04d.png"))
+
+		if out, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput(); err != nil {
+			monitoring.ExecFailed("ffmpeg")
 			return nil, fmt.Errorf("ffmpeg failed: %s : %s", err.Error(), out)
 		}
 		// we need to count them here tho.
@@ -162,33 +284,16 @@ func ProcessStage1(ctx context.Context, config *configure.Config, file string, i
 			if frameCount == 0 {
 				return nil, ErrUnknown
 			}
+			if err := limits.Check(0, 0, frameCount, 0); err != nil {
+				return nil, err
+			}
 			if frameCount > 1 {
-				// we need to calculate the frame timings, by looking at the old fps.
-				// :)
-				fpsData, err := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-select_streams", "v", "-of", "default=noprint_wrappers=1:nokey=1", "-show_entries", "stream=r_frame_rate", file).CombinedOutput()
-				if err != nil {
-					return nil, fmt.Errorf("ffprobe failed: %s : %s", err.Error(), fpsData)
-				}
-
-				fpsSplits := strings.Split(utils.B2S(fpsData), "/")
-				if len(fpsSplits) != 2 {
-					return nil, ErrBadResponseFFprobe
-				}
-
-				fpsNum, err := strconv.Atoi(strings.TrimSpace(fpsSplits[0]))
+				// the frame timings come from the timestamps of each frame so variable frame rate inputs keep their timing.
+				d, err := probeFrameDelays(ctx, file, frameCount)
 				if err != nil {
 					return nil, err
 				}
-
-				fpsDenom, err := strconv.Atoi(strings.TrimSpace(fpsSplits[1]))
-				if err != nil {
-					return nil, err
-				}
-
-				d := int(math.Floor(100 / (float64(fpsNum) / float64(fpsDenom))))
-				for i := 0; i < frameCount; i++ {
-					delay[i] = d
-				}
+				delay = d
 			}
 		}
 	case image.AVIF:
@@ -203,11 +308,12 @@ func ProcessStage1(ctx context.Context, config *configure.Config, file string, i
 			"avifdump",
 			"--codec", decoder,
 			"--png-compress", "0",
-			"--jobs", "all",
+			"--jobs", avif.Jobs(threads),
 			"--depth", "16",
 			file,
 			fmt.Sprintf("%s/dump_%%04d.png", frameDir),
 		).CombinedOutput(); err != nil {
+			monitoring.ExecFailed("avifdump")
 			return nil, fmt.Errorf("avifdump failed: %s : %s", err.Error(), out)
 		} else {
 			matches := avifDumpRe.FindAllStringSubmatch(utils.B2S(out), -1)
@@ -219,29 +325,58 @@ func ProcessStage1(ctx context.Context, config *configure.Config, file string, i
 			delay = make([]int, frameCount)
 			for i, m := range matches {
 				delay[i], _ = strconv.Atoi(m[1])
-				delay[i] /= 10
 			}
+
+			if err := limits.Check(0, 0, frameCount, sum(delay)); err != nil {
+				return nil, err
+			}
+		}
+	case image.HEIF:
+		// vips only decodes the primary image, HEIF sequences are treated as static.
+		if out, err := exec.CommandContext(ctx, "vips", "copy", file, fmt.Sprintf("%s/dump_0000.png", frameDir)).CombinedOutput(); err != nil {
+			monitoring.ExecFailed("vips")
+			return nil, fmt.Errorf("vips failed: %s : %s", err.Error(), out)
 		}
+
+		frameCount = 1
+		delay = make([]int, 1)
 	case image.WEBP:
 		// anim_dump
 		if out, err := exec.CommandContext(ctx, "anim_dump", "-folder", frameDir, file).CombinedOutput(); err != nil {
+			monitoring.ExecFailed("anim_dump")
 			return nil, fmt.Errorf("anim_dump failed: %s : %s", err.Error(), out)
 		}
 	default:
 		return nil, ErrUnknownFormat
 	}
 
+	// trimming happens before padding so the box is relative to the source frames and the padding is sized for the trimmed frames.
+	var trimmed *image.Rect
+	if trim.Enabled {
+		if trimmed, err = trimFrames(frameDir, frameCount, trim); err != nil {
+			return nil, err
+		}
+	}
+
+	// only the default fit pads to the aspect ratio, every other fit frames the image when it is resized in stage 2.
+	filter := "format=rgba"
+	if frm.fit == job.FitDefault {
+		filter = fmt.Sprintf("format=rgba,pad=h=if(gt(iw/ih\\,%d)\\,iw/%d\\,ih):x=(ow-iw)*%g:y=(oh-ih)*%g:color=%s", aspectRatioXY[0], aspectRatioXY[0], frm.x, frm.y, frm.ffmpegColour())
+	}
+
 	out, err := exec.CommandContext(ctx,
 		"ffmpeg",
+		"-threads", strconv.Itoa(threads),
 		"-f", "image2",
 		"-start_number", "0",
 		"-i", fmt.Sprintf("%s/%s", frameDir, "dump_%04d.png"),
-		"-vf", fmt.Sprintf("format=rgba,pad=h=if(gt(iw/ih\\,%d)\\,iw/%d\\,ih):x=0:y=(oh-ih):color=#00000000", aspectRatioXY[0], aspectRatioXY[0]),
+		"-vf", filter,
 		"-f", "image2",
 		"-start_number", "0",
 		"-y", fmt.Sprintf("%s/%s", frameDir, "dump_%04d.png"),
 	).CombinedOutput()
 	if err != nil {
+		monitoring.ExecFailed("ffmpeg")
 		return nil, fmt.Errorf("ffmpeg failed: %s : %s", err.Error(), out)
 	}
 
@@ -258,14 +393,41 @@ func ProcessStage1(ctx context.Context, config *configure.Config, file string, i
 	}
 
 	return &image.Image{
-		Dir:    dir,
-		Width:  uint16(pngCfg.Width),
-		Height: uint16(pngCfg.Height),
-		Delays: delay,
+		Dir:     dir,
+		Width:   uint16(pngCfg.Width),
+		Height:  uint16(pngCfg.Height),
+		Delays:  delay,
+		Trim:    trimmed,
+		Threads: threads,
 	}, nil
 }
 
-func ProcessStage2(ctx context.Context, config *configure.Config, img *image.Image, sizes map[string]job.ImageSize) error {
+func sum(values []int) int {
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+// vipsHeader reads a single integer field of the image header.
+func vipsHeader(ctx context.Context, file string, field string) (int, error) {
+	out, err := exec.CommandContext(ctx, "vipsheader", "-f", field, file).CombinedOutput()
+	if err != nil {
+		monitoring.ExecFailed("vipsheader")
+		return 0, fmt.Errorf("vipsheader failed: %s : %s", err.Error(), out)
+	}
+
+	return strconv.Atoi(strings.TrimSpace(utils.B2S(out)))
+}
+
+// ProcessStage2 resizes the frames to every size, onSize is called as each size is done and may be nil.
+func ProcessStage2(ctx context.Context, config *configure.Config, img *image.Image, sizes map[string]job.ImageSize, framing job.Framing, onSize func(name string)) error {
+	frm, err := parseFraming(framing)
+	if err != nil {
+		return err
+	}
+
 	for v := range sizes {
 		dir := path.Join(img.Dir, "frames", v)
 		if err := os.MkdirAll(dir, 0700); err != nil {
@@ -328,11 +490,15 @@ func ProcessStage2(ctx context.Context, config *configure.Config, img *image.Ima
 
 	for name, size := range sizes {
 		go func(name string, size job.ImageSize) {
-			errCh <- png.Edit(ctx, uniqueFrames, img.Dir, name, uint16(size.Width), uint16(size.Height))
+			w, h, force, placement := frm.resize(int(img.Width), int(img.Height), size)
+			err := png.Edit(ctx, uniqueFrames, img.Dir, name, uint16(w), uint16(h), force, placement)
+			if err == nil && onSize != nil {
+				onSize(name)
+			}
+			errCh <- err
 		}(name, size)
 	}
 
-	var err error
 	for i := 0; i < len(sizes); i++ {
 		err = multierror.Append(err, <-errCh).ErrorOrNil()
 	}
@@ -340,28 +506,82 @@ func ProcessStage2(ctx context.Context, config *configure.Config, img *image.Ima
 	return err
 }
 
-func ProcessStage3(ctx context.Context, config *configure.Config, img *image.Image, sizes map[string]job.ImageSize, settings uint64) ([]job.File, error) {
+// OutputError is the failure of a single output in stage 3, the other outputs carry on without it.
+type OutputError struct {
+	Format string
+	Size   string
+	Name   string
+	Err    error
+}
+
+func (e *OutputError) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.Name, e.Err.Error())
+}
+
+func (e *OutputError) Unwrap() error {
+	return e.Err
+}
+
+func outputError(format string, size string, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &OutputError{
+		Format: format,
+		Size:   size,
+		Name:   name,
+		Err:    err,
+	}
+}
+
+// ProcessStage3 encodes every enabled output, onFile is called with each file as it is done and how many outputs there are in total, it may be nil.
+// An output which fails does not stop the others, its error is an *OutputError and the files which were made are always returned.
+func ProcessStage3(ctx context.Context, config *configure.Config, img *image.Image, sizes map[string]job.ImageSize, settings uint64, opts job.EncodeOptions, onFile func(file job.File, total int)) ([]job.File, error) {
 	errCh := make(chan error)
 
 	wg := sync.WaitGroup{}
+	outputs := 0
+	add := func() {
+		outputs++
+		wg.Add(1)
+	}
+
+	// the threads the job was granted are shared by all of its encoders, so the encoders wait until every output
+	// has been started and the total is known. Each output is timed from then on so its time is of its encoder alone.
+	counted := make(chan struct{})
+	threads := 0
 
 	isAnimated := len(img.Delays) > 1
 
 	files := []job.File{}
 	fileChan := make(chan job.File)
-	start := time.Now()
+
+	// the dimensions of the outputs are whatever stage 2 produced for each size.
+	outSizes := map[string]job.ImageSize{}
+	for name := range sizes {
+		size, err := frameSize(path.Join(img.Dir, "frames", name, img.Frames[0]))
+		if err != nil {
+			return nil, err
+		}
+		outSizes[name] = size
+	}
 
 	// AVIF
 	if (settings&job.EnableOutputAnimatedAVIF != 0 && isAnimated) || (settings&job.EnableOutputStaticAVIF != 0 && !isAnimated) {
-		for name, size := range sizes {
-			wg.Add(1)
+		for name, size := range outSizes {
+			add()
 			go func(name string, size job.ImageSize) {
 				defer wg.Done()
-				err := avif.Encode(ctx, config, name, name, img.Dir, img.Frames, img.Delays)
+				<-counted
+				start := time.Now()
+				quality, err := searchQuality(path.Join(img.Dir, fmt.Sprintf("%s.avif", name)), opts.AVIF.MaxBytesFor(name), opts.AVIF, func(opts job.EncoderOptions) error {
+					return avif.Encode(ctx, config, name, name, img.Dir, img.Frames, img.Delays, threads, opts)
+				})
 				if err == nil {
 					info, err := os.Stat(path.Join(img.Dir, fmt.Sprintf("%s.avif", name)))
 					if err != nil {
-						errCh <- err
+						errCh <- outputError("image/avif", name, fmt.Sprintf("%s.avif", name), err)
 						return
 					}
 
@@ -370,27 +590,32 @@ func ProcessStage3(ctx context.Context, config *configure.Config, img *image.Ima
 						ContentType: "image/avif",
 						Size:        int(info.Size()),
 						Animated:    isAnimated,
-						Width:       int(float64(size.Height) / float64(img.Height) * float64(img.Width)),
+						Width:       size.Width,
 						Height:      size.Height,
 						TimeTaken:   time.Since(start),
+						Quality:     quality,
 					}
 				}
-				errCh <- err
+				errCh <- outputError("image/avif", name, fmt.Sprintf("%s.avif", name), err)
 			}(name, size)
 		}
 	}
 
 	// WEBP
 	if (settings&job.EnableOutputAnimatedWEBP != 0 && isAnimated) || (settings&job.EnableOutputStaticWEBP != 0 && !isAnimated) {
-		for name, size := range sizes {
-			wg.Add(1)
+		for name, size := range outSizes {
+			add()
 			go func(name string, size job.ImageSize) {
 				defer wg.Done()
-				err := webp.Encode(ctx, name, name, img.Dir, img.Frames, img.Delays)
+				<-counted
+				start := time.Now()
+				quality, err := searchQuality(path.Join(img.Dir, fmt.Sprintf("%s.webp", name)), opts.WEBP.MaxBytesFor(name), opts.WEBP, func(opts job.EncoderOptions) error {
+					return webp.Encode(ctx, name, name, img.Dir, img.Frames, img.Delays, opts)
+				})
 				if err == nil {
 					info, err := os.Stat(path.Join(img.Dir, fmt.Sprintf("%s.webp", name)))
 					if err != nil {
-						errCh <- err
+						errCh <- outputError("image/webp", name, fmt.Sprintf("%s.webp", name), err)
 						return
 					}
 
@@ -399,27 +624,68 @@ func ProcessStage3(ctx context.Context, config *configure.Config, img *image.Ima
 						ContentType: "image/webp",
 						Size:        int(info.Size()),
 						Animated:    isAnimated,
-						Width:       int(float64(size.Height) / float64(img.Height) * float64(img.Width)),
+						Width:       size.Width,
 						Height:      size.Height,
 						TimeTaken:   time.Since(start),
+						Quality:     quality,
 					}
 				}
-				errCh <- err
+				errCh <- outputError("image/webp", name, fmt.Sprintf("%s.webp", name), err)
+			}(name, size)
+		}
+	}
+
+	// JXL
+	if (settings&job.EnableOutputAnimatedJXL != 0 && isAnimated) || (settings&job.EnableOutputStaticJXL != 0 && !isAnimated) {
+		for name, size := range outSizes {
+			add()
+			go func(name string, size job.ImageSize) {
+				defer wg.Done()
+				<-counted
+				start := time.Now()
+				quality, err := searchQuality(path.Join(img.Dir, fmt.Sprintf("%s.jxl", name)), opts.JXL.MaxBytesFor(name), opts.JXL, func(opts job.EncoderOptions) error {
+					return jxl.Encode(ctx, name, name, img.Dir, img.Frames, img.Delays, opts)
+				})
+				if err == nil {
+					info, err := os.Stat(path.Join(img.Dir, fmt.Sprintf("%s.jxl", name)))
+					if err != nil {
+						errCh <- outputError("image/jxl", name, fmt.Sprintf("%s.jxl", name), err)
+						return
+					}
+
+					fileChan <- job.File{
+						Name:        fmt.Sprintf("%s.jxl", name),
+						ContentType: "image/jxl",
+						Size:        int(info.Size()),
+						Animated:    isAnimated,
+						Width:       size.Width,
+						Height:      size.Height,
+						TimeTaken:   time.Since(start),
+						Quality:     quality,
+					}
+				}
+				errCh <- outputError("image/jxl", name, fmt.Sprintf("%s.jxl", name), err)
 			}(name, size)
 		}
 	}
 
 	// GIF
 	if settings&job.EnableOutputAnimatedGIF != 0 && isAnimated {
-		for name, size := range sizes {
-			wg.Add(1)
+		for name, size := range outSizes {
+			add()
 			go func(name string, size job.ImageSize) {
 				defer wg.Done()
-				err := gif.Encode(ctx, name, name, img.Dir, img.Frames, img.Delays)
+				<-counted
+				start := time.Now()
+				var lossy, colours int
+				err := gif.Encode(ctx, name, name, img.Dir, img.Frames, img.Delays, opts.GIF)
+				if err == nil {
+					lossy, colours, err = gif.Shrink(ctx, img.Dir, name, opts.GIF.MaxBytesFor(name))
+				}
 				if err == nil {
 					info, err := os.Stat(path.Join(img.Dir, fmt.Sprintf("%s.gif", name)))
 					if err != nil {
-						errCh <- err
+						errCh <- outputError("image/gif", name, fmt.Sprintf("%s.gif", name), err)
 						return
 					}
 
@@ -428,27 +694,32 @@ func ProcessStage3(ctx context.Context, config *configure.Config, img *image.Ima
 						ContentType: "image/gif",
 						Size:        int(info.Size()),
 						Animated:    isAnimated,
-						Width:       int(float64(size.Height) / float64(img.Height) * float64(img.Width)),
+						Width:       size.Width,
 						Height:      size.Height,
 						TimeTaken:   time.Since(start),
+						Quality:     opts.GIF.Quality,
+						Lossy:       lossy,
+						Colours:     colours,
 					}
 				}
-				errCh <- err
+				errCh <- outputError("image/gif", name, fmt.Sprintf("%s.gif", name), err)
 			}(name, size)
 		}
 	}
 
 	// PNG
 	if settings&job.EnableOutputStaticPNG != 0 && !isAnimated {
-		for name, size := range sizes {
-			wg.Add(1)
+		for name, size := range outSizes {
+			add()
 			go func(name string, size job.ImageSize) {
 				defer wg.Done()
+				<-counted
+				start := time.Now()
 				err := png.Encode(ctx, path.Join(img.Dir, "frames", name, img.Frames[0]), path.Join(img.Dir, fmt.Sprintf("%s.png", name)))
 				if err == nil {
 					info, err := os.Stat(path.Join(img.Dir, fmt.Sprintf("%s.png", name)))
 					if err != nil {
-						errCh <- err
+						errCh <- outputError("image/png", name, fmt.Sprintf("%s.png", name), err)
 						return
 					}
 
@@ -457,28 +728,63 @@ func ProcessStage3(ctx context.Context, config *configure.Config, img *image.Ima
 						ContentType: "image/png",
 						Size:        int(info.Size()),
 						Animated:    false,
-						Width:       int(float64(size.Height) / float64(img.Height) * float64(img.Width)),
+						Width:       size.Width,
 						Height:      size.Height,
 						TimeTaken:   time.Since(start),
 					}
 				}
-				errCh <- err
+				errCh <- outputError("image/png", name, fmt.Sprintf("%s.png", name), err)
+			}(name, size)
+		}
+	}
+
+	// APNG
+	if settings&job.EnableOutputAnimatedPNG != 0 && isAnimated {
+		for name, size := range outSizes {
+			add()
+			go func(name string, size job.ImageSize) {
+				defer wg.Done()
+				<-counted
+				start := time.Now()
+				err := png.EncodeAnimation(ctx, name, name, img.Dir, img.Frames, img.Delays)
+				if err == nil {
+					info, err := os.Stat(path.Join(img.Dir, fmt.Sprintf("%s.png", name)))
+					if err != nil {
+						errCh <- outputError("image/apng", name, fmt.Sprintf("%s.png", name), err)
+						return
+					}
+
+					fileChan <- job.File{
+						Name:        fmt.Sprintf("%s.png", name),
+						ContentType: "image/apng",
+						Size:        int(info.Size()),
+						Animated:    isAnimated,
+						Width:       size.Width,
+						Height:      size.Height,
+						TimeTaken:   time.Since(start),
+					}
+				}
+				errCh <- outputError("image/apng", name, fmt.Sprintf("%s.png", name), err)
 			}(name, size)
 		}
 	}
 
 	// THUMBNAILS
 	if isAnimated && settings&job.EnableOutputAnimatedThumbanils != 0 {
-		for name, size := range sizes {
+		for name, size := range outSizes {
 			if settings&job.EnableOutputStaticAVIF != 0 {
-				wg.Add(1)
+				add()
 				go func(name string, size job.ImageSize) {
 					defer wg.Done()
-					err := avif.Encode(ctx, config, name, fmt.Sprintf("%s_static", name), img.Dir, img.Frames, img.Delays[:1])
+					<-counted
+					start := time.Now()
+					quality, err := searchQuality(path.Join(img.Dir, fmt.Sprintf("%s_static.avif", name)), opts.AVIF.MaxBytesFor(name), opts.AVIF, func(opts job.EncoderOptions) error {
+						return avif.Encode(ctx, config, name, fmt.Sprintf("%s_static", name), img.Dir, img.Frames, img.Delays[:1], threads, opts)
+					})
 					if err == nil {
 						info, err := os.Stat(path.Join(img.Dir, fmt.Sprintf("%s_static.avif", name)))
 						if err != nil {
-							errCh <- err
+							errCh <- outputError("image/avif", name, fmt.Sprintf("%s_static.avif", name), err)
 							return
 						}
 
@@ -487,23 +793,28 @@ func ProcessStage3(ctx context.Context, config *configure.Config, img *image.Ima
 							ContentType: "image/avif",
 							Size:        int(info.Size()),
 							Animated:    false,
-							Width:       int(float64(size.Height) / float64(img.Height) * float64(img.Width)),
+							Width:       size.Width,
 							Height:      size.Height,
 							TimeTaken:   time.Since(start),
+							Quality:     quality,
 						}
 					}
-					errCh <- err
+					errCh <- outputError("image/avif", name, fmt.Sprintf("%s_static.avif", name), err)
 				}(name, size)
 			}
 			if settings&job.EnableOutputStaticWEBP != 0 {
-				wg.Add(1)
+				add()
 				go func(name string, size job.ImageSize) {
 					defer wg.Done()
-					err := webp.Encode(ctx, name, fmt.Sprintf("%s_static", name), img.Dir, img.Frames, img.Delays[:1])
+					<-counted
+					start := time.Now()
+					quality, err := searchQuality(path.Join(img.Dir, fmt.Sprintf("%s_static.webp", name)), opts.WEBP.MaxBytesFor(name), opts.WEBP, func(opts job.EncoderOptions) error {
+						return webp.Encode(ctx, name, fmt.Sprintf("%s_static", name), img.Dir, img.Frames, img.Delays[:1], opts)
+					})
 					if err == nil {
 						info, err := os.Stat(path.Join(img.Dir, fmt.Sprintf("%s_static.webp", name)))
 						if err != nil {
-							errCh <- err
+							errCh <- outputError("image/webp", name, fmt.Sprintf("%s_static.webp", name), err)
 							return
 						}
 
@@ -512,24 +823,57 @@ func ProcessStage3(ctx context.Context, config *configure.Config, img *image.Ima
 							ContentType: "image/webp",
 							Size:        int(info.Size()),
 							Animated:    false,
-							Width:       int(float64(size.Height) / float64(img.Height) * float64(img.Width)),
+							Width:       size.Width,
 							Height:      size.Height,
 							TimeTaken:   time.Since(start),
+							Quality:     quality,
 						}
 					}
-					errCh <- err
+					errCh <- outputError("image/webp", name, fmt.Sprintf("%s_static.webp", name), err)
 				}(name, size)
 
 			}
+			if settings&job.EnableOutputStaticJXL != 0 {
+				add()
+				go func(name string, size job.ImageSize) {
+					defer wg.Done()
+					<-counted
+					start := time.Now()
+					quality, err := searchQuality(path.Join(img.Dir, fmt.Sprintf("%s_static.jxl", name)), opts.JXL.MaxBytesFor(name), opts.JXL, func(opts job.EncoderOptions) error {
+						return jxl.Encode(ctx, name, fmt.Sprintf("%s_static", name), img.Dir, img.Frames, img.Delays[:1], opts)
+					})
+					if err == nil {
+						info, err := os.Stat(path.Join(img.Dir, fmt.Sprintf("%s_static.jxl", name)))
+						if err != nil {
+							errCh <- outputError("image/jxl", name, fmt.Sprintf("%s_static.jxl", name), err)
+							return
+						}
+
+						fileChan <- job.File{
+							Name:        fmt.Sprintf("%s_static.jxl", name),
+							ContentType: "image/jxl",
+							Size:        int(info.Size()),
+							Animated:    false,
+							Width:       size.Width,
+							Height:      size.Height,
+							TimeTaken:   time.Since(start),
+							Quality:     quality,
+						}
+					}
+					errCh <- outputError("image/jxl", name, fmt.Sprintf("%s_static.jxl", name), err)
+				}(name, size)
+			}
 			if settings&job.EnableOutputStaticPNG != 0 {
-				wg.Add(1)
+				add()
 				go func(name string, size job.ImageSize) {
 					defer wg.Done()
+					<-counted
+					start := time.Now()
 					err := png.Encode(ctx, path.Join(img.Dir, "frames", name, img.Frames[0]), path.Join(img.Dir, fmt.Sprintf("%s_static.png", name)))
 					if err == nil {
 						info, err := os.Stat(path.Join(img.Dir, fmt.Sprintf("%s_static.png", name)))
 						if err != nil {
-							errCh <- err
+							errCh <- outputError("image/png", name, fmt.Sprintf("%s_static.png", name), err)
 							return
 						}
 
@@ -538,17 +882,25 @@ func ProcessStage3(ctx context.Context, config *configure.Config, img *image.Ima
 							ContentType: "image/png",
 							Size:        int(info.Size()),
 							Animated:    false,
-							Width:       int(float64(size.Height) / float64(img.Height) * float64(img.Width)),
+							Width:       size.Width,
 							Height:      size.Height,
 							TimeTaken:   time.Since(start),
 						}
 					}
-					errCh <- err
+					errCh <- outputError("image/png", name, fmt.Sprintf("%s_static.png", name), err)
 				}(name, size)
 			}
 		}
 	}
 
+	if img.Threads > 0 && outputs > 0 {
+		threads = img.Threads / outputs
+		if threads < 1 {
+			threads = 1
+		}
+	}
+	close(counted)
+
 	go func() {
 		wg.Wait()
 		close(errCh)
@@ -568,8 +920,12 @@ func ProcessStage3(ctx context.Context, config *configure.Config, img *image.Ima
 
 	go func() {
 		defer wg2.Done()
+		// every output has been started by now so the total is known.
 		for f := range fileChan {
 			files = append(files, f)
+			if onFile != nil {
+				onFile(f, outputs)
+			}
 		}
 	}()
 
@@ -577,3 +933,21 @@ func ProcessStage3(ctx context.Context, config *configure.Config, img *image.Ima
 
 	return files, multierror.Append(err, os.RemoveAll(path.Join(img.Dir, "frames"))).ErrorOrNil()
 }
+
+func frameSize(file string) (job.ImageSize, error) {
+	f, err := os.OpenFile(file, os.O_RDONLY, 0600)
+	if err != nil {
+		return job.ImageSize{}, fmt.Errorf("open file failed: %s", err.Error())
+	}
+	defer f.Close()
+
+	cfg, err := nPng.DecodeConfig(f)
+	if err != nil {
+		return job.ImageSize{}, err
+	}
+
+	return job.ImageSize{
+		Width:  cfg.Width,
+		Height: cfg.Height,
+	}, nil
+}
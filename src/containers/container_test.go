@@ -0,0 +1,210 @@
+package containers
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	nImage "image"
+	"image/color"
+	"image/draw"
+	nPng "image/png"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/seventv/ImageProcessor/src/image"
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ToTypeBmff(t *testing.T) {
+	// the leading bytes of files produced by common encoders and devices.
+	tests := []struct {
+		name    string
+		header  string
+		imgType image.ImageType
+	}{
+		{"avifenc still", "00000020667479706176696600000000617669666d6966316d6961664d413142", image.AVIF},
+		{"avifenc animated", "0000002c66747970617669730000000061766973617669666d73663169736f386d6966316d6961664d413142", image.AVIF},
+		{"avif with mif1 major brand", "0000001c667479706d696631000000006d696631617669666d696166", image.AVIF},
+		{"iphone heic", "000000186674797068656963000000006d69663168656963", image.HEIF},
+		{"heif with mif1 major brand", "00000018667479706d696631000000006d69663168656963", image.HEIF},
+		{"iphone mov", "0000001466747970717420202005030071742020", image.MOV},
+		{"legacy mov", "0000000877696465000bc7fe6d646174", image.MOV},
+		{"ffmpeg mp4", "000000206674797069736f6d0000020069736f6d69736f32617663316d703431", image.MP4},
+		{"camera mp4", "00000018667479706d7034320000000069736f6d6d703432", image.MP4},
+		{"sony mp4", "00000018667479704d534e56012900274d534e566d703432", image.MP4},
+		{"dash mp4", "0000001866747970646173680000000069736f366d703431", image.MP4},
+		{"3gp without iso brands", "0000001466747970336770340000020033677034", ""},
+	}
+
+	for _, test := range tests {
+		data, err := hex.DecodeString(test.header)
+		if !assert.ErrorIs(t, err, nil, test.name) {
+			continue
+		}
+
+		imgType, err := ToType(data)
+		if test.imgType == "" {
+			assert.ErrorIs(t, err, ErrUnknownFormat, test.name)
+		} else {
+			assert.ErrorIs(t, err, nil, test.name)
+			assert.Equal(t, test.imgType, imgType, test.name)
+		}
+	}
+}
+
+func Test_parsePacketDelays(t *testing.T) {
+	// a variable frame rate recording, listed in decode order with a b-frame.
+	delays, ok := parsePacketDelays("0.000000,0.033333\n0.100000,0.033333\n0.033333,0.066667\n0.150000,0.040000\n")
+	assert.True(t, ok, "The packets were parsed")
+	assert.Equal(t, []int{33, 67, 50, 40}, delays, "The delays follow the timestamps")
+
+	delays, ok = parsePacketDelays("1.000000,N/A\n1.041667,N/A\n1.083333,N/A\n")
+	assert.True(t, ok, "The packets were parsed")
+	assert.Equal(t, []int{42, 41, 41}, delays, "The last delay is the average when there is no duration")
+
+	_, ok = parsePacketDelays("N/A,N/A\n0.5,0.5\n")
+	assert.False(t, ok, "Missing timestamps are rejected")
+
+	_, ok = parsePacketDelays("0.000000,0.033333\n")
+	assert.False(t, ok, "A single packet has no timing")
+}
+
+func Test_parseFraming(t *testing.T) {
+	frm, err := parseFraming(job.Framing{})
+	assert.ErrorIs(t, err, nil, "The default framing is valid")
+	assert.Equal(t, "#00000000", frm.ffmpegColour(), "The default pad is transparent")
+	assert.Equal(t, [2]float64{0, 1}, [2]float64{frm.x, frm.y}, "The default framing is anchored bottom left")
+
+	frm, err = parseFraming(job.Framing{Fit: job.FitContain, PadColor: "#ff8000"})
+	assert.ErrorIs(t, err, nil, "A contain framing is valid")
+	assert.Equal(t, "#ff8000ff", frm.ffmpegColour(), "A colour without alpha is opaque")
+	assert.Equal(t, [2]float64{0.5, 0.5}, [2]float64{frm.x, frm.y}, "Other fits are centered")
+
+	_, err = parseFraming(job.Framing{Fit: "zoom"})
+	assert.ErrorIs(t, err, ErrBadFraming, "Unknown fits are rejected")
+	_, err = parseFraming(job.Framing{Gravity: "up"})
+	assert.ErrorIs(t, err, ErrBadFraming, "Unknown gravities are rejected")
+	_, err = parseFraming(job.Framing{PadColor: "red"})
+	assert.ErrorIs(t, err, ErrBadFraming, "Bad colours are rejected")
+}
+
+func Test_framingResize(t *testing.T) {
+	size := job.ImageSize{Width: 128, Height: 128}
+
+	tests := []struct {
+		fit       job.Fit
+		w, h      int
+		force     bool
+		placement bool
+	}{
+		{job.FitDefault, 128, 128, false, false},
+		{job.FitNone, 128, 128, false, false},
+		{job.FitStretch, 128, 128, true, false},
+		{job.FitContain, 128, 128, false, true},
+		// a 400x200 frame scaled to fill 128x128 is 256x128 before it is cropped.
+		{job.FitCover, 256, 128, true, true},
+	}
+
+	for _, test := range tests {
+		frm, _ := parseFraming(job.Framing{Fit: test.fit})
+		w, h, force, placement := frm.resize(400, 200, size)
+		assert.Equal(t, test.w, w, test.fit)
+		assert.Equal(t, test.h, h, test.fit)
+		assert.Equal(t, test.force, force, test.fit)
+		assert.Equal(t, test.placement, placement != nil, test.fit)
+		if placement != nil {
+			assert.Equal(t, size.Width, placement.Width, test.fit)
+			assert.Equal(t, size.Height, placement.Height, test.fit)
+		}
+	}
+}
+
+func writeTestFrame(t *testing.T, file string, content nImage.Rectangle, alpha uint8) {
+	img := nImage.NewNRGBA(nImage.Rect(0, 0, 64, 32))
+	draw.Draw(img, content, &nImage.Uniform{C: color.NRGBA{R: 255, A: alpha}}, nImage.Point{}, draw.Src)
+
+	f, err := os.Create(file)
+	assert.ErrorIs(t, err, nil, "no error when creating the frame")
+	defer f.Close()
+
+	assert.ErrorIs(t, nPng.Encode(f, img), nil, "no error when encoding the frame")
+}
+
+func Test_trimFrames(t *testing.T) {
+	dir := t.TempDir()
+
+	writeTestFrame(t, path.Join(dir, "dump_0000.png"), nImage.Rect(10, 4, 20, 8), 255)
+	writeTestFrame(t, path.Join(dir, "dump_0001.png"), nImage.Rect(16, 6, 30, 12), 255)
+	// faint pixels below the threshold are not content.
+	writeTestFrame(t, path.Join(dir, "dump_0002.png"), nImage.Rect(0, 0, 64, 32), 8)
+
+	box, err := trimFrames(dir, 3, job.Trim{Enabled: true, AlphaThreshold: 16, Margin: 2})
+	assert.ErrorIs(t, err, nil, "no error when trimming")
+	assert.Equal(t, &image.Rect{X: 8, Y: 2, Width: 24, Height: 12}, box, "the box is the union of the content plus the margin")
+
+	for i := 0; i < 3; i++ {
+		img, err := decodeFrame(path.Join(dir, fmt.Sprintf("dump_%04d.png", i)))
+		assert.ErrorIs(t, err, nil, "no error when decoding the trimmed frame")
+		assert.Equal(t, 24, img.Bounds().Dx(), "every frame is cropped")
+		assert.Equal(t, 12, img.Bounds().Dy(), "every frame is cropped")
+	}
+
+	// the margin never grows the frames past the canvas.
+	box, err = trimFrames(dir, 3, job.Trim{Enabled: true, Margin: 100})
+	assert.ErrorIs(t, err, nil, "no error when trimming")
+	assert.Equal(t, &image.Rect{X: 0, Y: 0, Width: 24, Height: 12}, box, "the box is clamped to the canvas")
+}
+
+func Test_searchQuality(t *testing.T) {
+	file := path.Join(t.TempDir(), "out")
+
+	encodes := 0
+	// a fake encoder which writes 10 bytes per quality plus a 10 byte header and 2000 bytes when lossless.
+	encode := func(opts job.EncoderOptions) error {
+		encodes++
+		size := 2000
+		if opts.Lossless == nil || !*opts.Lossless {
+			size = 75 * 10
+			if opts.Quality != nil {
+				size = *opts.Quality*10 + 10
+			}
+		}
+		return os.WriteFile(file, make([]byte, size), 0600)
+	}
+
+	quality, err := searchQuality(file, 0, job.EncoderOptions{}, encode)
+	assert.ErrorIs(t, err, nil, "no error without a budget")
+	assert.Nil(t, quality, "the quality was never set")
+	assert.Equal(t, 1, encodes, "encoded once without a budget")
+
+	encodes = 0
+	quality, err = searchQuality(file, 555, job.EncoderOptions{}, encode)
+	assert.ErrorIs(t, err, nil, "no error when the output can fit")
+	assert.Equal(t, 54, *quality, "the highest quality which fits")
+	size, _ := fileSize(file)
+	assert.Equal(t, int64(550), size, "the file was left at the highest quality which fits")
+
+	lossless := true
+	_, err = searchQuality(file, 555, job.EncoderOptions{Lossless: &lossless}, encode)
+	assert.True(t, errors.Is(err, job.ErrOverBudget), "lossless outputs are not made lossy")
+
+	_, err = searchQuality(file, 5, job.EncoderOptions{}, encode)
+	budgetErr := &job.BudgetError{}
+	assert.True(t, errors.As(err, &budgetErr), "a budget error when nothing fits")
+	assert.Equal(t, int64(10), budgetErr.Size, "the smallest output is reported")
+}
+
+func Test_parseProbeInput(t *testing.T) {
+	info, err := parseProbeInput("width=1920\nheight=1080\nnb_read_packets=300\nduration=10.010000\n")
+	assert.ErrorIs(t, err, nil, "no error when parsing a video")
+	assert.Equal(t, probeInfo{width: 1920, height: 1080, frames: 300, duration: 10010}, info)
+
+	info, err = parseProbeInput("width=64\nheight=32\nnb_read_packets=1\nduration=N/A\n")
+	assert.ErrorIs(t, err, nil, "no error when parsing a still image")
+	assert.Equal(t, probeInfo{width: 64, height: 32, frames: 1}, info)
+
+	_, err = parseProbeInput("duration=1.0\n")
+	assert.ErrorIs(t, err, ErrBadResponseFFprobe, "an input without a video stream is rejected")
+}
@@ -0,0 +1,174 @@
+package containers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/seventv/ImageProcessor/src/monitoring"
+	"github.com/seventv/ImageProcessor/src/utils"
+)
+
+// probeFrameDelays works out the delay of every frame in milliseconds from the packet timestamps of the first video stream.
+// If the timestamps are unusable, for example when the packet count does not match the dumped frames, it falls back to the stream frame rate.
+func probeFrameDelays(ctx context.Context, file string, frameCount int) ([]int, error) {
+	out, err := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-select_streams", "v:0", "-of", "csv=p=0", "-show_entries", "packet=pts_time,duration_time", file).CombinedOutput()
+	if err != nil {
+		monitoring.ExecFailed("ffprobe")
+		return nil, fmt.Errorf("ffprobe failed: %s : %s", err.Error(), out)
+	}
+
+	delays, ok := parsePacketDelays(utils.B2S(out))
+	if ok && len(delays) == frameCount {
+		return delays, nil
+	}
+
+	return probeFrameRateDelays(ctx, file, frameCount)
+}
+
+// parsePacketDelays turns the pts_time,duration_time csv output of ffprobe into per frame delays in milliseconds.
+// Packets are listed in decode order so they are sorted by their presentation time first.
+func parsePacketDelays(data string) ([]int, bool) {
+	type packet struct {
+		pts      float64
+		duration float64
+	}
+
+	packets := []packet{}
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		splits := strings.Split(strings.TrimSpace(line), ",")
+		if len(splits) == 0 || splits[0] == "" {
+			continue
+		}
+
+		pts, err := strconv.ParseFloat(splits[0], 64)
+		if err != nil {
+			// packets without a timestamp mean we cannot trust any of the timings.
+			return nil, false
+		}
+
+		p := packet{pts: pts}
+		if len(splits) > 1 {
+			p.duration, _ = strconv.ParseFloat(splits[1], 64)
+		}
+
+		packets = append(packets, p)
+	}
+
+	if len(packets) < 2 {
+		return nil, false
+	}
+
+	sort.SliceStable(packets, func(i, j int) bool {
+		return packets[i].pts < packets[j].pts
+	})
+
+	// rounding the timestamps rather than the deltas stops rounding errors from drifting over long inputs.
+	delays := make([]int, len(packets))
+	total := 0
+	for i := 0; i < len(packets)-1; i++ {
+		delays[i] = int(math.Round(packets[i+1].pts*1000)) - int(math.Round(packets[i].pts*1000))
+		total += delays[i]
+	}
+
+	last := packets[len(packets)-1]
+	if last.duration > 0 {
+		delays[len(delays)-1] = int(math.Round(last.duration * 1000))
+	} else {
+		delays[len(delays)-1] = total / (len(delays) - 1)
+	}
+
+	return delays, true
+}
+
+// probeFrameRateDelays gives every frame the same delay based on the frame rate of the first video stream.
+func probeFrameRateDelays(ctx context.Context, file string, frameCount int) ([]int, error) {
+	fpsData, err := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-select_streams", "v:0", "-of", "default=noprint_wrappers=1:nokey=1", "-show_entries", "stream=r_frame_rate", file).CombinedOutput()
+	if err != nil {
+		monitoring.ExecFailed("ffprobe")
+		return nil, fmt.Errorf("ffprobe failed: %s : %s", err.Error(), fpsData)
+	}
+
+	fpsSplits := strings.Split(utils.B2S(fpsData), "/")
+	if len(fpsSplits) != 2 {
+		return nil, ErrBadResponseFFprobe
+	}
+
+	fpsNum, err := strconv.Atoi(strings.TrimSpace(fpsSplits[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	fpsDenom, err := strconv.Atoi(strings.TrimSpace(fpsSplits[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	if fpsNum == 0 || fpsDenom == 0 {
+		return nil, ErrBadResponseFFprobe
+	}
+
+	delays := make([]int, frameCount)
+	d := int(math.Floor(1000 / (float64(fpsNum) / float64(fpsDenom))))
+	for i := range delays {
+		delays[i] = d
+	}
+
+	return delays, nil
+}
+
+type probeInfo struct {
+	width  int
+	height int
+	frames int
+	// duration in milliseconds.
+	duration int
+}
+
+// probeInput reads the dimensions, packet count and duration of the first video stream without decoding it.
+func probeInput(ctx context.Context, file string) (probeInfo, error) {
+	out, err := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-select_streams", "v:0", "-count_packets", "-of", "default=noprint_wrappers=1", "-show_entries", "stream=width,height,nb_read_packets:format=duration", file).CombinedOutput()
+	if err != nil {
+		monitoring.ExecFailed("ffprobe")
+		return probeInfo{}, fmt.Errorf("ffprobe failed: %s : %s", err.Error(), out)
+	}
+
+	return parseProbeInput(utils.B2S(out))
+}
+
+// parseProbeInput reads the key=value output of ffprobe, values which are N/A are left as 0.
+func parseProbeInput(data string) (probeInfo, error) {
+	info := probeInfo{}
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		splits := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(splits) != 2 || splits[1] == "N/A" {
+			continue
+		}
+
+		switch splits[0] {
+		case "width":
+			info.width, _ = strconv.Atoi(splits[1])
+		case "height":
+			info.height, _ = strconv.Atoi(splits[1])
+		case "nb_read_packets":
+			info.frames, _ = strconv.Atoi(splits[1])
+		case "duration":
+			d, _ := strconv.ParseFloat(splits[1], 64)
+			info.duration = int(math.Round(d * 1000))
+		}
+	}
+
+	if info.width == 0 || info.height == 0 {
+		return info, ErrBadResponseFFprobe
+	}
+
+	if info.frames == 0 {
+		info.frames = 1
+	}
+
+	return info, nil
+}
@@ -0,0 +1,113 @@
+package containers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/seventv/ImageProcessor/src/containers/png"
+	"github.com/seventv/ImageProcessor/src/job"
+)
+
+var ErrBadFraming = fmt.Errorf("bad framing")
+
+// gravity as fractions of the free space placed before the content on each axis.
+var gravities = map[job.Gravity][2]float64{
+	job.GravityCenter:    {0.5, 0.5},
+	job.GravityNorth:     {0.5, 0},
+	job.GravitySouth:     {0.5, 1},
+	job.GravityEast:      {1, 0.5},
+	job.GravityWest:      {0, 0.5},
+	job.GravityNorthEast: {1, 0},
+	job.GravityNorthWest: {0, 0},
+	job.GravitySouthEast: {1, 1},
+	job.GravitySouthWest: {0, 1},
+}
+
+type framing struct {
+	fit    job.Fit
+	x      float64
+	y      float64
+	colour color.NRGBA
+}
+
+func parseFraming(f job.Framing) (framing, error) {
+	out := framing{
+		fit: f.Fit,
+	}
+
+	switch f.Fit {
+	case job.FitDefault, job.FitContain, job.FitCover, job.FitStretch, job.FitNone:
+	default:
+		return out, fmt.Errorf("%w: unknown fit %s", ErrBadFraming, f.Fit)
+	}
+
+	gravity := f.Gravity
+	if gravity == "" {
+		if f.Fit == job.FitDefault {
+			// emotes have always been anchored to the bottom left.
+			gravity = job.GravitySouthWest
+		} else {
+			gravity = job.GravityCenter
+		}
+	}
+
+	g, ok := gravities[gravity]
+	if !ok {
+		return out, fmt.Errorf("%w: unknown gravity %s", ErrBadFraming, f.Gravity)
+	}
+	out.x = g[0]
+	out.y = g[1]
+
+	if f.PadColor != "" {
+		c, err := hex.DecodeString(strings.TrimPrefix(f.PadColor, "#"))
+		if err != nil || (len(c) != 3 && len(c) != 4) {
+			return out, fmt.Errorf("%w: bad pad color %s", ErrBadFraming, f.PadColor)
+		}
+
+		out.colour = color.NRGBA{c[0], c[1], c[2], 255}
+		if len(c) == 4 {
+			out.colour.A = c[3]
+		}
+	}
+
+	return out, nil
+}
+
+// ffmpegColour formats the pad colour for ffmpeg filters.
+func (f framing) ffmpegColour() string {
+	return fmt.Sprintf("#%02x%02x%02x%02x", f.colour.R, f.colour.G, f.colour.B, f.colour.A)
+}
+
+// resize works out how frames of width by height are resized into size.
+// When force is false the dimensions are a box the frame is fit within, otherwise the frame is resized to exactly them.
+// The placement is nil when the resized frame is the output.
+func (f framing) resize(width, height int, size job.ImageSize) (w, h int, force bool, placement *png.Placement) {
+	scaleX := float64(size.Width) / float64(width)
+	scaleY := float64(size.Height) / float64(height)
+
+	switch f.fit {
+	case job.FitStretch:
+		return size.Width, size.Height, true, nil
+	case job.FitCover:
+		scale := math.Max(scaleX, scaleY)
+		w = int(math.Max(math.Round(float64(width)*scale), float64(size.Width)))
+		h = int(math.Max(math.Round(float64(height)*scale), float64(size.Height)))
+		force = true
+	case job.FitContain:
+		w = size.Width
+		h = size.Height
+	default:
+		return size.Width, size.Height, false, nil
+	}
+
+	return w, h, force, &png.Placement{
+		Width:      size.Width,
+		Height:     size.Height,
+		X:          f.x,
+		Y:          f.y,
+		Background: f.colour,
+	}
+}
@@ -3,42 +3,111 @@ package gif
 import (
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
 	"path"
+	"strconv"
+
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/seventv/ImageProcessor/src/monitoring"
 )
 
-func Encode(ctx context.Context, name string, outName string, dir string, frames []string, delays []int) error {
+func Encode(ctx context.Context, name string, outName string, dir string, frames []string, delays []int, opts job.EncoderOptions) error {
 	gifFile := path.Join(dir, fmt.Sprintf("%s.gif", outName))
 
-	args := make([]string, len(delays)+2)
-	dlay := make([]string, len(delays))
+	args := make([]string, 0, len(delays)+4)
+	if opts.Quality != nil {
+		args = append(args, "--quality", strconv.Itoa(*opts.Quality))
+	}
 	for i := range delays {
-		dlay[i] = fmt.Sprint(delays[i])
-		args[i] = path.Join(dir, "frames", name, frames[i])
+		args = append(args, path.Join(dir, "frames", name, frames[i]))
 	}
 
-	args[len(args)-2] = "--output"
-	args[len(args)-1] = gifFile
+	args = append(args, "--output", gifFile)
 
 	if out, err := exec.CommandContext(ctx, "gifski", args...).CombinedOutput(); err != nil {
+		monitoring.ExecFailed("gifski")
 		return fmt.Errorf("gifski failed: %s : %s", err.Error(), out)
 	}
 
 	args = make([]string, len(delays)*2+2)
 	args[0] = "-b"
 	args[1] = gifFile
+	// gif delays are in centiseconds
 	for i, v := range delays {
-		args[2+i*2] = fmt.Sprintf("--delay=%d", v)
+		args[2+i*2] = fmt.Sprintf("--delay=%d", (v+5)/10)
 		args[2+i*2+1] = fmt.Sprintf("#%d", i)
 	}
 
 	if out, err := exec.CommandContext(ctx, "gifsicle", args...).CombinedOutput(); err != nil {
+		monitoring.ExecFailed("gifsicle")
 		return fmt.Errorf("gifsicle failed: %s : %s", err.Error(), out)
 	}
 
 	if out, err := exec.CommandContext(ctx, "gifsicle", "-b", "-O3", gifFile).CombinedOutput(); err != nil {
+		monitoring.ExecFailed("gifsicle")
 		return fmt.Errorf("gifsicle failed: %s : %s", err.Error(), out)
 	}
 
 	return nil
 }
+
+// shrinkSteps are tried in order until the gif fits, each trades more quality for size.
+var shrinkSteps = []struct {
+	lossy   int
+	colours int
+}{
+	{20, 256},
+	{40, 256},
+	{60, 256},
+	{80, 192},
+	{100, 128},
+	{140, 64},
+	{200, 32},
+}
+
+// Shrink reduces the gif with lossy compression and fewer colours until it is at most maxBytes.
+// It returns the lossy level and colour count that were used, both are 0 when the gif already fit.
+func Shrink(ctx context.Context, dir string, outName string, maxBytes int64) (int, int, error) {
+	gifFile := path.Join(dir, fmt.Sprintf("%s.gif", outName))
+
+	info, err := os.Stat(gifFile)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if maxBytes <= 0 || info.Size() <= maxBytes {
+		return 0, 0, nil
+	}
+
+	smallest := info.Size()
+	shrunkFile := path.Join(dir, fmt.Sprintf("%s.shrunk.gif", outName))
+	defer os.Remove(shrunkFile)
+
+	for _, step := range shrinkSteps {
+		out, err := exec.CommandContext(ctx,
+			"gifsicle", "-O3",
+			fmt.Sprintf("--lossy=%d", step.lossy),
+			fmt.Sprintf("--colors=%d", step.colours),
+			gifFile, "-o", shrunkFile,
+		).CombinedOutput()
+		if err != nil {
+			monitoring.ExecFailed("gifsicle")
+			return 0, 0, fmt.Errorf("gifsicle failed: %s : %s", err.Error(), out)
+		}
+
+		if info, err = os.Stat(shrunkFile); err != nil {
+			return 0, 0, err
+		}
+
+		if info.Size() <= maxBytes {
+			return step.lossy, step.colours, os.Rename(shrunkFile, gifFile)
+		}
+
+		if info.Size() < smallest {
+			smallest = info.Size()
+		}
+	}
+
+	return 0, 0, &job.BudgetError{Name: path.Base(gifFile), MaxBytes: maxBytes, Size: smallest}
+}
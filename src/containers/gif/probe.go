@@ -0,0 +1,160 @@
+package gif
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// https://www.w3.org/Graphics/GIF/spec-gif89a.txt
+
+var (
+	ErrBadHeader = fmt.Errorf("bad gif header")
+	ErrBadBlock  = fmt.Errorf("bad gif block")
+	ErrNoFrames  = fmt.Errorf("gif has no frames")
+)
+
+const (
+	blockExtension  = 0x21
+	blockImage      = 0x2c
+	blockTrailer    = 0x3b
+	labelGraphics   = 0xf9
+	labelApp        = 0xff
+	flagColourTable = 0x80
+)
+
+type Info struct {
+	Width  int
+	Height int
+	// LoopCount is the number of times the animation loops, 0 means forever.
+	LoopCount int
+	Frames    []Frame
+}
+
+type Frame struct {
+	X      int
+	Y      int
+	Width  int
+	Height int
+	// Duration in milliseconds.
+	Duration int
+}
+
+// colourTable is the size in bytes of the colour table described by the packed field.
+func colourTable(packed byte) int {
+	if packed&flagColourTable == 0 {
+		return 0
+	}
+
+	return 3 << ((packed & 0x07) + 1)
+}
+
+// subBlocks returns the data of a run of sub-blocks and the number of bytes they took up including the terminator.
+func subBlocks(data []byte) ([]byte, int, error) {
+	out := []byte{}
+	i := 0
+	for {
+		if i >= len(data) {
+			return nil, 0, ErrBadBlock
+		}
+
+		size := int(data[i])
+		i++
+		if size == 0 {
+			return out, i, nil
+		}
+
+		if i+size > len(data) {
+			return nil, 0, ErrBadBlock
+		}
+
+		// only the first sub-block is ever needed.
+		if len(out) == 0 {
+			out = data[i : i+size]
+		}
+		i += size
+	}
+}
+
+// Probe reads the screen size, loop count and per frame timing of a GIF without decoding any of the frames.
+func Probe(data []byte) (*Info, error) {
+	if len(data) < 13 || string(data[0:3]) != "GIF" {
+		return nil, ErrBadHeader
+	}
+
+	info := &Info{
+		Width:  int(binary.LittleEndian.Uint16(data[6:8])),
+		Height: int(binary.LittleEndian.Uint16(data[8:10])),
+	}
+
+	i := 13 + colourTable(data[10])
+	delay := 0
+
+	for i < len(data) {
+		switch data[i] {
+		case blockExtension:
+			if i+2 > len(data) {
+				return nil, ErrBadBlock
+			}
+
+			label := data[i+1]
+			block, n, err := subBlocks(data[i+2:])
+			if err != nil {
+				return nil, err
+			}
+
+			switch label {
+			case labelGraphics:
+				if len(block) < 4 {
+					return nil, ErrBadBlock
+				}
+				// gif delays are in centiseconds
+				delay = int(binary.LittleEndian.Uint16(block[1:3])) * 10
+			case labelApp:
+				// the loop count lives in the sub-block after the NETSCAPE2.0 identifier.
+				if string(block) == "NETSCAPE2.0" {
+					rest, _, err := subBlocks(data[i+2+1+len(block):])
+					if err == nil && len(rest) >= 3 && rest[0] == 1 {
+						info.LoopCount = int(binary.LittleEndian.Uint16(rest[1:3]))
+					}
+				}
+			}
+
+			i += 2 + n
+		case blockImage:
+			if i+10 > len(data) {
+				return nil, ErrBadBlock
+			}
+
+			info.Frames = append(info.Frames, Frame{
+				X:        int(binary.LittleEndian.Uint16(data[i+1 : i+3])),
+				Y:        int(binary.LittleEndian.Uint16(data[i+3 : i+5])),
+				Width:    int(binary.LittleEndian.Uint16(data[i+5 : i+7])),
+				Height:   int(binary.LittleEndian.Uint16(data[i+7 : i+9])),
+				Duration: delay,
+			})
+			delay = 0
+
+			// the lzw minimum code size comes before the image data.
+			i += 10 + colourTable(data[i+9]) + 1
+			if i > len(data) {
+				return nil, ErrBadBlock
+			}
+
+			_, n, err := subBlocks(data[i:])
+			if err != nil {
+				return nil, err
+			}
+			i += n
+		case blockTrailer:
+			i = len(data)
+		default:
+			return nil, ErrBadBlock
+		}
+	}
+
+	if len(info.Frames) == 0 {
+		return nil, ErrNoFrames
+	}
+
+	return info, nil
+}
@@ -0,0 +1,53 @@
+package gif
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	nGif "image/gif"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testGIF(loopCount int, delays []int) []byte {
+	palette := color.Palette{color.Black, color.White}
+
+	g := &nGif.GIF{LoopCount: loopCount}
+	for i, d := range delays {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(i, i, 8, 6), palette))
+		g.Delay = append(g.Delay, d)
+	}
+	g.Config = image.Config{Width: 8, Height: 6}
+
+	buf := bytes.NewBuffer(nil)
+	_ = nGif.EncodeAll(buf, g)
+	return buf.Bytes()
+}
+
+func Test_Probe(t *testing.T) {
+	info, err := Probe(testGIF(3, []int{2, 10, 150}))
+	if !assert.ErrorIs(t, err, nil, "The gif was probed") {
+		return
+	}
+
+	assert.Equal(t, 8, info.Width)
+	assert.Equal(t, 6, info.Height)
+	assert.Equal(t, 3, info.LoopCount)
+	assert.Equal(t, []Frame{
+		{X: 0, Y: 0, Width: 8, Height: 6, Duration: 20},
+		{X: 1, Y: 1, Width: 7, Height: 5, Duration: 100},
+		{X: 2, Y: 2, Width: 6, Height: 4, Duration: 1500},
+	}, info.Frames, "Frames are read with their delays in milliseconds")
+
+	info, err = Probe(testGIF(-1, []int{0}))
+	assert.ErrorIs(t, err, nil, "A static gif was probed")
+	assert.Len(t, info.Frames, 1)
+
+	_, err = Probe([]byte("GIF89a"))
+	assert.ErrorIs(t, err, ErrBadHeader)
+
+	data := testGIF(0, []int{1, 1})
+	_, err = Probe(data[:len(data)-8])
+	assert.ErrorIs(t, err, ErrBadBlock, "A truncated gif is rejected")
+}
@@ -0,0 +1,19 @@
+package heif
+
+import (
+	"github.com/seventv/ImageProcessor/src/containers/bmff"
+)
+
+func Test(data []byte) bool {
+	ftyp, ok := bmff.ParseFtyp(data)
+	if !ok {
+		return false
+	}
+
+	// AVIF is also a HEIF and lists mif1 and msf1 as compatible brands.
+	if ftyp.HasBrand(bmff.AvifBrands...) {
+		return false
+	}
+
+	return ftyp.HasBrand(bmff.HeifBrands...)
+}
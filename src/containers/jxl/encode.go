@@ -0,0 +1,62 @@
+package jxl
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"strconv"
+
+	"github.com/seventv/ImageProcessor/src/containers/png"
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/seventv/ImageProcessor/src/monitoring"
+)
+
+func Encode(ctx context.Context, name string, outName string, dir string, frames []string, delays []int, opts job.EncoderOptions) error {
+	jxlFile := path.Join(dir, fmt.Sprintf("%s.jxl", outName))
+
+	input := path.Join(dir, "frames", name, frames[0])
+	if len(delays) != 1 {
+		// cjxl only reads animations from apng and gif, gif would throw away the alpha and colours.
+		input = path.Join(dir, "frames", name, fmt.Sprintf("%s.apng", outName))
+
+		newFrames := make([]string, len(frames))
+		for i, v := range frames {
+			newFrames[i] = path.Join(dir, "frames", name, v)
+		}
+
+		if err := png.WriteAnimation(input, newFrames, delays); err != nil {
+			return err
+		}
+	}
+
+	// animations are lossless and stills are lossy unless asked otherwise.
+	lossless := len(delays) != 1
+	if opts.Lossless != nil {
+		lossless = *opts.Lossless
+	}
+
+	effort := 7
+	if opts.Effort != nil {
+		effort = *opts.Effort
+	}
+
+	args := []string{input, jxlFile, "--effort", strconv.Itoa(effort), "--quiet"}
+	if lossless {
+		// distance 0 is mathematically lossless.
+		args = append(args, "--distance", "0")
+	} else if opts.Quality != nil {
+		args = append(args, "--quality", strconv.Itoa(*opts.Quality))
+	} else {
+		// distance 1 is visually lossless.
+		args = append(args, "--distance", "1")
+	}
+
+	out, err := exec.CommandContext(ctx, "cjxl", args...).CombinedOutput()
+	if err != nil {
+		monitoring.ExecFailed("cjxl")
+		err = fmt.Errorf("cjxl failed: %s : %s", err.Error(), out)
+	}
+
+	return err
+}
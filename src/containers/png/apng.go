@@ -0,0 +1,613 @@
+package png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	nPng "image/png"
+	"math"
+	"os"
+)
+
+// https://wiki.mozilla.org/APNG_Specification
+
+var (
+	ErrNotAnimated = fmt.Errorf("png is not animated")
+	ErrBadChunk    = fmt.Errorf("bad png chunk")
+	ErrBadFrame    = fmt.Errorf("bad apng frame")
+)
+
+var signature = []byte{0x89, 'P', 'N', 'G', 0x0D, 0x0A, 0x1A, 0x0A}
+
+type DisposeOp uint8
+
+const (
+	DisposeNone DisposeOp = iota
+	DisposeBackground
+	DisposePrevious
+)
+
+type BlendOp uint8
+
+const (
+	BlendSource BlendOp = iota
+	BlendOver
+)
+
+type Animation struct {
+	Width  int
+	Height int
+	// LoopCount is the number of times the animation plays, 0 means forever.
+	LoopCount int
+	Frames    []Frame
+
+	ihdr      []byte
+	ancillary []chunk
+}
+
+type Frame struct {
+	X      int
+	Y      int
+	Width  int
+	Height int
+	// Duration in milliseconds.
+	Duration int
+	Dispose  DisposeOp
+	Blend    BlendOp
+
+	data [][]byte
+}
+
+type chunk struct {
+	typ  string
+	data []byte
+}
+
+func chunks(data []byte) ([]chunk, error) {
+	if len(data) < len(signature) || !bytes.Equal(data[:len(signature)], signature) {
+		return nil, ErrBadChunk
+	}
+	data = data[len(signature):]
+
+	out := []chunk{}
+	for len(data) != 0 {
+		if len(data) < 12 {
+			return nil, ErrBadChunk
+		}
+
+		size := int(binary.BigEndian.Uint32(data[0:4]))
+		if size < 0 || size > len(data)-12 {
+			return nil, ErrBadChunk
+		}
+
+		c := chunk{
+			typ:  string(data[4:8]),
+			data: data[8 : 8+size],
+		}
+		out = append(out, c)
+		data = data[12+size:]
+
+		if c.typ == "IEND" {
+			break
+		}
+	}
+
+	return out, nil
+}
+
+// IsAnimated reports if an acTL chunk comes before the image data.
+// It reads as far as it can without checking the chunks, so a damaged png only counts as animated when the chunk was seen.
+func IsAnimated(data []byte) bool {
+	if len(data) < len(signature) || !bytes.Equal(data[:len(signature)], signature) {
+		return false
+	}
+	data = data[len(signature):]
+
+	for len(data) >= 12 {
+		size := int(binary.BigEndian.Uint32(data[0:4]))
+		switch string(data[4:8]) {
+		case "acTL":
+			return true
+		case "IDAT":
+			return false
+		}
+
+		if size < 0 || size > len(data)-12 {
+			return false
+		}
+		data = data[12+size:]
+	}
+
+	return false
+}
+
+// ParseAnimation reads the frame control chunks of an APNG, it returns ErrNotAnimated for a plain PNG.
+func ParseAnimation(data []byte) (*Animation, error) {
+	cs, err := chunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cs) == 0 || cs[0].typ != "IHDR" || len(cs[0].data) != 13 {
+		return nil, ErrBadChunk
+	}
+
+	anim := &Animation{
+		Width:  int(binary.BigEndian.Uint32(cs[0].data[0:4])),
+		Height: int(binary.BigEndian.Uint32(cs[0].data[4:8])),
+		ihdr:   cs[0].data,
+	}
+
+	animated := false
+	seenIDAT := false
+	var current *Frame
+
+	for _, c := range cs[1:] {
+		switch c.typ {
+		case "acTL":
+			if len(c.data) != 8 {
+				return nil, ErrBadChunk
+			}
+			animated = true
+			anim.LoopCount = int(binary.BigEndian.Uint32(c.data[4:8]))
+		case "fcTL":
+			if len(c.data) != 26 {
+				return nil, ErrBadChunk
+			}
+
+			num := float64(binary.BigEndian.Uint16(c.data[20:22]))
+			den := float64(binary.BigEndian.Uint16(c.data[22:24]))
+			if den == 0 {
+				den = 100
+			}
+
+			anim.Frames = append(anim.Frames, Frame{
+				Width:    int(binary.BigEndian.Uint32(c.data[4:8])),
+				Height:   int(binary.BigEndian.Uint32(c.data[8:12])),
+				X:        int(binary.BigEndian.Uint32(c.data[12:16])),
+				Y:        int(binary.BigEndian.Uint32(c.data[16:20])),
+				Duration: int(math.Round(num * 1000 / den)),
+				Dispose:  DisposeOp(c.data[24]),
+				Blend:    BlendOp(c.data[25]),
+			})
+			current = &anim.Frames[len(anim.Frames)-1]
+		case "IDAT":
+			seenIDAT = true
+			// the default image is only part of the animation when an fcTL came before it.
+			if current != nil {
+				current.data = append(current.data, c.data)
+			}
+		case "fdAT":
+			if len(c.data) < 4 || current == nil {
+				return nil, ErrBadChunk
+			}
+			current.data = append(current.data, c.data[4:])
+		case "PLTE", "tRNS", "gAMA", "cHRM", "sRGB", "iCCP", "sBIT":
+			if !seenIDAT {
+				anim.ancillary = append(anim.ancillary, c)
+			}
+		}
+	}
+
+	if !animated {
+		return nil, ErrNotAnimated
+	}
+
+	if len(anim.Frames) == 0 {
+		return nil, ErrBadFrame
+	}
+
+	for _, f := range anim.Frames {
+		if len(f.data) == 0 || f.Width == 0 || f.Height == 0 || f.X+f.Width > anim.Width || f.Y+f.Height > anim.Height {
+			return nil, ErrBadFrame
+		}
+	}
+
+	return anim, nil
+}
+
+func writeChunk(buf *bytes.Buffer, typ string, data []byte) {
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.WriteString(typ)
+	buf.Write(data)
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	_ = binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
+
+// decodeFrame rebuilds a standalone PNG out of the frame data and decodes it.
+func (a *Animation) decodeFrame(f Frame) (image.Image, error) {
+	buf := bytes.NewBuffer(nil)
+	buf.Write(signature)
+
+	ihdr := append([]byte{}, a.ihdr...)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(f.Width))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(f.Height))
+	writeChunk(buf, "IHDR", ihdr)
+
+	for _, c := range a.ancillary {
+		writeChunk(buf, c.typ, c.data)
+	}
+
+	for _, d := range f.data {
+		writeChunk(buf, "IDAT", d)
+	}
+
+	writeChunk(buf, "IEND", nil)
+
+	return nPng.Decode(buf)
+}
+
+// Dump composites every frame onto the canvas following the blend and dispose ops and writes the result of each frame to fmt.Sprintf(pattern, i).
+func (a *Animation) Dump(pattern string) error {
+	canvas := image.NewNRGBA(image.Rect(0, 0, a.Width, a.Height))
+	enc := nPng.Encoder{CompressionLevel: nPng.BestSpeed}
+
+	for i, f := range a.Frames {
+		img, err := a.decodeFrame(f)
+		if err != nil {
+			return err
+		}
+
+		rect := image.Rect(f.X, f.Y, f.X+f.Width, f.Y+f.Height)
+
+		dispose := f.Dispose
+		if i == 0 && dispose == DisposePrevious {
+			dispose = DisposeBackground
+		}
+
+		var previous *image.NRGBA
+		if dispose == DisposePrevious {
+			previous = image.NewNRGBA(rect)
+			draw.Draw(previous, rect, canvas, rect.Min, draw.Src)
+		}
+
+		op := draw.Src
+		if f.Blend == BlendOver {
+			op = draw.Over
+		}
+		draw.Draw(canvas, rect, img, img.Bounds().Min, op)
+
+		out, err := os.OpenFile(fmt.Sprintf(pattern, i), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+
+		err = enc.Encode(out, canvas)
+		_ = out.Close()
+		if err != nil {
+			return err
+		}
+
+		switch dispose {
+		case DisposeBackground:
+			draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+		case DisposePrevious:
+			draw.Draw(canvas, rect, previous, rect.Min, draw.Src)
+		}
+	}
+
+	return nil
+}
+
+// IHDR colour types
+const (
+	colourTypeTruecolour      = 2
+	colourTypePalette         = 3
+	colourTypeTruecolourAlpha = 6
+)
+
+// readFrame decodes the frame onto a canvas of the given bounds, fully transparent pixels are all the same colour.
+func readFrame(file string, bounds image.Rectangle) (*image.NRGBA, error) {
+	f, err := os.OpenFile(file, os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, err := nPng.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if bounds.Empty() {
+		bounds = image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy())
+	}
+
+	canvas := image.NewNRGBA(bounds)
+	draw.Draw(canvas, bounds, img, img.Bounds().Min, draw.Src)
+
+	for i := 0; i < len(canvas.Pix); i += 4 {
+		if canvas.Pix[i+3] == 0 {
+			copy(canvas.Pix[i:i+3], []byte{0, 0, 0})
+		}
+	}
+
+	return canvas, nil
+}
+
+// changedBounds is the smallest rectangle holding every pixel which differs between the two frames.
+func changedBounds(prev *image.NRGBA, cur *image.NRGBA) image.Rectangle {
+	out := image.Rectangle{}
+	for y := cur.Rect.Min.Y; y < cur.Rect.Max.Y; y++ {
+		for x := cur.Rect.Min.X; x < cur.Rect.Max.X; x++ {
+			i := cur.PixOffset(x, y)
+			if !bytes.Equal(prev.Pix[i:i+4], cur.Pix[i:i+4]) {
+				out = out.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+
+	// a frame must cover at least one pixel.
+	if out.Empty() {
+		out = image.Rect(cur.Rect.Min.X, cur.Rect.Min.Y, cur.Rect.Min.X+1, cur.Rect.Min.Y+1)
+	}
+
+	return out
+}
+
+type animationWriter struct {
+	colourType byte
+	palette    map[color.NRGBA]byte
+	colours    []color.NRGBA
+}
+
+// scanline converts a row of pixels to the colour type of the animation.
+func (w *animationWriter) scanline(img *image.NRGBA, rect image.Rectangle, y int) []byte {
+	pix := img.Pix[img.PixOffset(rect.Min.X, y):img.PixOffset(rect.Max.X, y)]
+
+	switch w.colourType {
+	case colourTypePalette:
+		out := make([]byte, rect.Dx())
+		for i := range out {
+			out[i] = w.palette[color.NRGBA{pix[i*4], pix[i*4+1], pix[i*4+2], pix[i*4+3]}]
+		}
+		return out
+	case colourTypeTruecolour:
+		out := make([]byte, rect.Dx()*3)
+		for i := 0; i < rect.Dx(); i++ {
+			copy(out[i*3:i*3+3], pix[i*4:i*4+3])
+		}
+		return out
+	}
+
+	return append([]byte{}, pix...)
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	} else if pb <= pc {
+		return b
+	}
+	return c
+}
+
+// filter picks the filter with the smallest sum of absolute differences, the usual heuristic for truecolour images.
+// https://www.w3.org/TR/png/#12Filter-selection
+func filter(cur, prev []byte, bpp int) []byte {
+	best := []byte{}
+	bestSum := -1
+
+	for ft := byte(0); ft < 5; ft++ {
+		out := make([]byte, len(cur)+1)
+		out[0] = ft
+		sum := 0
+		for i, x := range cur {
+			var a, b, c byte
+			if i >= bpp {
+				a = cur[i-bpp]
+				c = prev[i-bpp]
+			}
+			b = prev[i]
+
+			var v byte
+			switch ft {
+			case 0:
+				v = x
+			case 1:
+				v = x - a
+			case 2:
+				v = x - b
+			case 3:
+				v = x - byte((int(a)+int(b))/2)
+			case 4:
+				v = x - paeth(a, b, c)
+			}
+
+			out[i+1] = v
+			sum += abs(int(int8(v)))
+		}
+
+		if bestSum == -1 || sum < bestSum {
+			best = out
+			bestSum = sum
+		}
+	}
+
+	return best
+}
+
+// compress deflates the region of the image, palette images are not filtered.
+func (w *animationWriter) compress(img *image.NRGBA, rect image.Rectangle) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	zw, _ := zlib.NewWriterLevel(buf, zlib.BestCompression)
+
+	bpp := 4
+	if w.colourType == colourTypeTruecolour {
+		bpp = 3
+	}
+
+	var prev []byte
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		cur := w.scanline(img, rect, y)
+
+		var row []byte
+		if w.colourType == colourTypePalette {
+			row = append([]byte{0}, cur...)
+		} else {
+			if prev == nil {
+				prev = make([]byte, len(cur))
+			}
+			row = filter(cur, prev, bpp)
+		}
+		prev = cur
+
+		if _, err := zw.Write(row); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WriteAnimation stitches the frames into an APNG which loops forever, delays are in milliseconds.
+// The canvas is the size of the first frame, frames only store the region which changed since the previous frame
+// and the animation is paletted when every frame fits in 256 colours, otherwise it is truecolour.
+func WriteAnimation(file string, frames []string, delays []int) error {
+	if len(frames) == 0 || len(frames) != len(delays) {
+		return ErrBadFrame
+	}
+
+	first, err := readFrame(frames[0], image.Rectangle{})
+	if err != nil {
+		return err
+	}
+	bounds := first.Rect
+
+	// the first pass decides the colour type.
+	w := &animationWriter{palette: map[color.NRGBA]byte{}}
+	opaque := true
+	paletted := true
+	for i, frame := range frames {
+		img := first
+		if i != 0 {
+			if img, err = readFrame(frame, bounds); err != nil {
+				return err
+			}
+		}
+
+		for p := 0; p < len(img.Pix); p += 4 {
+			c := color.NRGBA{img.Pix[p], img.Pix[p+1], img.Pix[p+2], img.Pix[p+3]}
+			opaque = opaque && c.A == 255
+			if _, ok := w.palette[c]; paletted && !ok {
+				if len(w.colours) == 256 {
+					paletted = false
+					continue
+				}
+				w.palette[c] = byte(len(w.colours))
+				w.colours = append(w.colours, c)
+			}
+		}
+	}
+
+	switch {
+	case paletted:
+		w.colourType = colourTypePalette
+	case opaque:
+		w.colourType = colourTypeTruecolour
+	default:
+		w.colourType = colourTypeTruecolourAlpha
+	}
+
+	buf := bytes.NewBuffer(nil)
+	buf.Write(signature)
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(bounds.Dy()))
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = w.colourType
+	writeChunk(buf, "IHDR", ihdr)
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:4], uint32(len(frames)))
+	writeChunk(buf, "acTL", actl)
+
+	if w.colourType == colourTypePalette {
+		plte := make([]byte, 0, len(w.colours)*3)
+		trns := make([]byte, 0, len(w.colours))
+		for _, c := range w.colours {
+			plte = append(plte, c.R, c.G, c.B)
+			trns = append(trns, c.A)
+		}
+		writeChunk(buf, "PLTE", plte)
+		if !opaque {
+			writeChunk(buf, "tRNS", trns)
+		}
+	}
+
+	seq := uint32(0)
+	var prev *image.NRGBA
+	for i, frame := range frames {
+		img := first
+		if i != 0 {
+			if img, err = readFrame(frame, bounds); err != nil {
+				return err
+			}
+		}
+
+		// the default image has to cover the whole canvas.
+		rect := bounds
+		if prev != nil {
+			rect = changedBounds(prev, img)
+		}
+		prev = img
+
+		data, err := w.compress(img, rect)
+		if err != nil {
+			return err
+		}
+
+		delay := delays[i]
+		if delay > math.MaxUint16 {
+			delay = math.MaxUint16
+		}
+
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:4], seq)
+		binary.BigEndian.PutUint32(fctl[4:8], uint32(rect.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:12], uint32(rect.Dy()))
+		binary.BigEndian.PutUint32(fctl[12:16], uint32(rect.Min.X))
+		binary.BigEndian.PutUint32(fctl[16:20], uint32(rect.Min.Y))
+		binary.BigEndian.PutUint16(fctl[20:22], uint16(delay))
+		binary.BigEndian.PutUint16(fctl[22:24], 1000)
+		fctl[24] = byte(DisposeNone)
+		fctl[25] = byte(BlendSource)
+		writeChunk(buf, "fcTL", fctl)
+		seq++
+
+		if i == 0 {
+			writeChunk(buf, "IDAT", data)
+		} else {
+			fdat := make([]byte, 4, len(data)+4)
+			binary.BigEndian.PutUint32(fdat, seq)
+			writeChunk(buf, "fdAT", append(fdat, data...))
+			seq++
+		}
+	}
+
+	writeChunk(buf, "IEND", nil)
+
+	return os.WriteFile(file, buf.Bytes(), 0600)
+}
@@ -0,0 +1,304 @@
+package png
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	nPng "image/png"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testFrame struct {
+	img      *image.NRGBA
+	x, y     int
+	num, den uint16
+	dispose  DisposeOp
+	blend    BlendOp
+}
+
+func testSolid(w, h int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < w*h; i++ {
+		copy(img.Pix[i*4:], []byte{c.R, c.G, c.B, c.A})
+	}
+	return img
+}
+
+// translucent stops the encoder from dropping the alpha channel of opaque frames, every frame of an apng shares the colour type of the IHDR.
+type translucent struct {
+	*image.NRGBA
+}
+
+func (translucent) Opaque() bool {
+	return false
+}
+
+// testIDAT encodes the image as 8 bit rgba and returns its IHDR and IDAT payloads.
+func testIDAT(img *image.NRGBA) ([]byte, []byte) {
+	buf := bytes.NewBuffer(nil)
+	_ = nPng.Encode(buf, translucent{img})
+
+	cs, _ := chunks(buf.Bytes())
+	ihdr := []byte{}
+	idat := []byte{}
+	for _, c := range cs {
+		switch c.typ {
+		case "IHDR":
+			ihdr = c.data
+		case "IDAT":
+			idat = append(idat, c.data...)
+		}
+	}
+	return ihdr, idat
+}
+
+func testAPNG(w, h int, plays uint32, frames []testFrame) []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.Write(signature)
+
+	ihdr, _ := testIDAT(testSolid(w, h, color.NRGBA{}))
+	writeChunk(buf, "IHDR", ihdr)
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:4], uint32(len(frames)))
+	binary.BigEndian.PutUint32(actl[4:8], plays)
+	writeChunk(buf, "acTL", actl)
+
+	seq := uint32(0)
+	for i, f := range frames {
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:4], seq)
+		binary.BigEndian.PutUint32(fctl[4:8], uint32(f.img.Rect.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:12], uint32(f.img.Rect.Dy()))
+		binary.BigEndian.PutUint32(fctl[12:16], uint32(f.x))
+		binary.BigEndian.PutUint32(fctl[16:20], uint32(f.y))
+		binary.BigEndian.PutUint16(fctl[20:22], f.num)
+		binary.BigEndian.PutUint16(fctl[22:24], f.den)
+		fctl[24] = byte(f.dispose)
+		fctl[25] = byte(f.blend)
+		writeChunk(buf, "fcTL", fctl)
+		seq++
+
+		_, idat := testIDAT(f.img)
+		if i == 0 {
+			writeChunk(buf, "IDAT", idat)
+		} else {
+			fdat := make([]byte, 4, 4+len(idat))
+			binary.BigEndian.PutUint32(fdat, seq)
+			writeChunk(buf, "fdAT", append(fdat, idat...))
+			seq++
+		}
+	}
+
+	writeChunk(buf, "IEND", nil)
+	return buf.Bytes()
+}
+
+func Test_ParseAnimation(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	data := testAPNG(4, 4, 2, []testFrame{
+		{img: testSolid(4, 4, red), num: 1, den: 30, dispose: DisposeNone, blend: BlendSource},
+		{img: testSolid(2, 2, red), x: 1, y: 2, num: 7, den: 0, dispose: DisposePrevious, blend: BlendOver},
+	})
+
+	assert.True(t, Test(data), "The apng is a png")
+
+	anim, err := ParseAnimation(data)
+	assert.ErrorIs(t, err, nil, "The apng was parsed")
+	assert.Equal(t, 4, anim.Width)
+	assert.Equal(t, 4, anim.Height)
+	assert.Equal(t, 2, anim.LoopCount)
+	assert.Len(t, anim.Frames, 2)
+
+	assert.Equal(t, 33, anim.Frames[0].Duration, "1/30th of a second")
+	assert.Equal(t, 70, anim.Frames[1].Duration, "a zero denominator means 1/100th")
+	assert.Equal(t, 1, anim.Frames[1].X)
+	assert.Equal(t, 2, anim.Frames[1].Y)
+	assert.Equal(t, DisposePrevious, anim.Frames[1].Dispose)
+	assert.Equal(t, BlendOver, anim.Frames[1].Blend)
+
+	buf := bytes.NewBuffer(nil)
+	_ = nPng.Encode(buf, testSolid(4, 4, red))
+	_, err = ParseAnimation(buf.Bytes())
+	assert.ErrorIs(t, err, ErrNotAnimated, "A plain png is not animated")
+
+	assert.True(t, IsAnimated(data), "The apng has an acTL chunk")
+	assert.False(t, IsAnimated(buf.Bytes()), "A plain png has no acTL chunk")
+
+	damaged := append(buf.Bytes()[:len(buf.Bytes())-4], 0, 0)
+	_, err = ParseAnimation(damaged)
+	assert.Error(t, err, "A damaged png cannot be parsed")
+	assert.False(t, IsAnimated(damaged), "A damaged plain png is still not animated")
+}
+
+func Test_AnimationDump(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	clear := color.NRGBA{0, 0, 0, 0}
+	halfGreen := color.NRGBA{0, 255, 0, 128}
+
+	data := testAPNG(4, 4, 0, []testFrame{
+		{img: testSolid(4, 4, red), num: 1, den: 10, dispose: DisposeNone, blend: BlendSource},
+		// blended over the red canvas then restored.
+		{img: testSolid(2, 2, halfGreen), x: 0, y: 0, num: 1, den: 10, dispose: DisposePrevious, blend: BlendOver},
+		// replaces the pixels it covers then cleared.
+		{img: testSolid(2, 2, blue), x: 2, y: 2, num: 1, den: 10, dispose: DisposeBackground, blend: BlendSource},
+		{img: testSolid(1, 1, clear), x: 0, y: 3, num: 1, den: 10, dispose: DisposeNone, blend: BlendOver},
+	})
+
+	anim, err := ParseAnimation(data)
+	if !assert.ErrorIs(t, err, nil, "The apng was parsed") {
+		return
+	}
+
+	dir := t.TempDir()
+	pattern := path.Join(dir, "dump_%04d.png")
+	assert.ErrorIs(t, anim.Dump(pattern), nil, "The frames were dumped")
+
+	frame := func(i int) image.Image {
+		f, err := os.Open(fmt.Sprintf(pattern, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		img, err := nPng.Decode(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+
+	at := func(img image.Image, x, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+	}
+
+	assert.Equal(t, red, at(frame(0), 0, 0), "The first frame fills the canvas")
+
+	blended := at(frame(1), 0, 0)
+	assert.True(t, blended.R > 100 && blended.G > 100 && blended.A == 255, "The second frame is blended over the first: %v", blended)
+	assert.Equal(t, red, at(frame(1), 3, 3), "Pixels outside the frame are kept")
+
+	assert.Equal(t, red, at(frame(2), 0, 0), "The second frame was restored to the previous canvas")
+	assert.Equal(t, blue, at(frame(2), 3, 3), "The third frame replaced the pixels")
+
+	assert.Equal(t, clear, at(frame(3), 3, 3), "The third frame was cleared to the background")
+	assert.Equal(t, red, at(frame(3), 0, 3), "Blending a transparent pixel keeps the canvas")
+}
+
+func Test_WriteAnimation(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	clear := color.NRGBA{0, 0, 0, 0}
+
+	dir := t.TempDir()
+	frames := []string{path.Join(dir, "a.png"), path.Join(dir, "b.png")}
+	for i, c := range []color.NRGBA{red, clear} {
+		f, err := os.Create(frames[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = nPng.Encode(f, testSolid(3, 2, c))
+		_ = f.Close()
+	}
+
+	file := path.Join(dir, "anim.png")
+	assert.ErrorIs(t, WriteAnimation(file, frames, []int{40, 1500}), nil, "The apng was written")
+
+	data, _ := os.ReadFile(file)
+	assert.True(t, Test(data), "The apng is a valid png")
+
+	img, err := nPng.Decode(bytes.NewReader(data))
+	assert.ErrorIs(t, err, nil, "The default image decodes")
+	assert.Equal(t, red, color.NRGBAModel.Convert(img.At(2, 1)), "The default image is the first frame")
+
+	anim, err := ParseAnimation(data)
+	if !assert.ErrorIs(t, err, nil, "The apng was parsed") {
+		return
+	}
+
+	assert.Equal(t, 3, anim.Width)
+	assert.Equal(t, 2, anim.Height)
+	assert.Equal(t, 0, anim.LoopCount, "The apng loops forever")
+	assert.Equal(t, byte(colourTypePalette), anim.ihdr[9], "Two colours fit in a palette")
+	assert.Len(t, anim.Frames, 2)
+	assert.Equal(t, 40, anim.Frames[0].Duration)
+	assert.Equal(t, 1500, anim.Frames[1].Duration)
+
+	last, err := anim.decodeFrame(anim.Frames[1])
+	assert.ErrorIs(t, err, nil, "The second frame decodes")
+	assert.Equal(t, clear, color.NRGBAModel.Convert(last.At(0, 0)), "The second frame keeps its alpha")
+}
+
+func Test_WriteAnimationRoundTrip(t *testing.T) {
+	gradient := func(alpha bool) *image.NRGBA {
+		img := image.NewNRGBA(image.Rect(0, 0, 20, 16))
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 20; x++ {
+				c := color.NRGBA{uint8(x * 12), uint8(y * 15), uint8(x * y), 255}
+				if alpha {
+					c.A = uint8(x*12 + 10)
+				}
+				img.SetNRGBA(x, y, c)
+			}
+		}
+		return img
+	}
+
+	tests := []struct {
+		name       string
+		alpha      bool
+		colourType byte
+	}{
+		{"opaque", false, colourTypeTruecolour},
+		{"translucent", true, colourTypeTruecolourAlpha},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+
+		changed := gradient(test.alpha)
+		changed.SetNRGBA(5, 7, color.NRGBA{1, 2, 3, 255})
+		changed.SetNRGBA(8, 9, color.NRGBA{1, 2, 3, 255})
+
+		imgs := []*image.NRGBA{gradient(test.alpha), changed, changed}
+		frames := make([]string, len(imgs))
+		for i, img := range imgs {
+			frames[i] = path.Join(dir, fmt.Sprintf("%d.png", i))
+			f, err := os.Create(frames[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = nPng.Encode(f, img)
+			_ = f.Close()
+		}
+
+		file := path.Join(dir, "anim.png")
+		assert.ErrorIs(t, WriteAnimation(file, frames, []int{10, 20, 30}), nil, test.name)
+
+		data, _ := os.ReadFile(file)
+		anim, err := ParseAnimation(data)
+		if !assert.ErrorIs(t, err, nil, test.name) {
+			continue
+		}
+
+		assert.Equal(t, test.colourType, anim.ihdr[9], "%s: colour type", test.name)
+		assert.Equal(t, image.Rect(5, 7, 9, 10), image.Rect(anim.Frames[1].X, anim.Frames[1].Y, anim.Frames[1].X+anim.Frames[1].Width, anim.Frames[1].Y+anim.Frames[1].Height), "%s: only the changed region is stored", test.name)
+		assert.Equal(t, 1, anim.Frames[2].Width*anim.Frames[2].Height, "%s: an unchanged frame stores a single pixel", test.name)
+
+		pattern := path.Join(dir, "dump_%04d.png")
+		assert.ErrorIs(t, anim.Dump(pattern), nil, test.name)
+
+		for i, want := range imgs {
+			got, err := readFrame(fmt.Sprintf(pattern, i), image.Rectangle{})
+			if !assert.ErrorIs(t, err, nil, test.name) {
+				continue
+			}
+			assert.Equal(t, want.Pix, got.Pix, "%s: frame %d round trips", test.name, i)
+		}
+	}
+}
@@ -3,11 +3,64 @@ package png
 import (
 	"context"
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	nPng "image/png"
+	"math"
+	"os"
 	"os/exec"
 	"path"
+
+	"github.com/seventv/ImageProcessor/src/monitoring"
 )
 
-func Edit(ctx context.Context, frames []string, dir string, name string, width uint16, height uint16) error {
+// Placement puts a resized frame onto a canvas of exactly Width by Height.
+// X and Y are the fraction of the free space placed before the frame, a frame larger than the canvas is cropped.
+type Placement struct {
+	Width      int
+	Height     int
+	X          float64
+	Y          float64
+	Background color.NRGBA
+}
+
+// Place draws the image in file onto the canvas described by the placement and overwrites the file.
+func (p Placement) Place(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+
+	img, err := nPng.Decode(f)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+
+	bounds := img.Bounds()
+	offset := image.Pt(
+		int(math.Round(float64(p.Width-bounds.Dx())*p.X)),
+		int(math.Round(float64(p.Height-bounds.Dy())*p.Y)),
+	)
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, p.Width, p.Height))
+	// the background only fills the padding, the frame replaces it so transparent pixels of the frame stay transparent
+	// just like the pad filter of ffmpeg does for the default fit.
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(p.Background), image.Point{}, draw.Src)
+	draw.Draw(canvas, bounds.Sub(bounds.Min).Add(offset), img, bounds.Min, draw.Src)
+
+	out, err := os.OpenFile(file, os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return nPng.Encode(out, canvas)
+}
+
+// Edit resizes the frames to fit within width by height, or to exactly width by height when force is set, and then applies the placement if there is one.
+func Edit(ctx context.Context, frames []string, dir string, name string, width uint16, height uint16, force bool, placement *Placement) error {
 	files := make([]string, len(frames)+4)
 	for i := 0; i < len(frames); i++ {
 		files[i+4] = path.Join(dir, "frames", frames[i])
@@ -16,15 +69,28 @@ func Edit(ctx context.Context, frames []string, dir string, name string, width u
 	files[1] = path.Join(name, "%s.png")
 	files[2] = "--size"
 	files[3] = fmt.Sprintf("%dx%d", width, height)
+	if force {
+		files[3] += "!"
+	}
 
 	out, err := exec.CommandContext(ctx, "vipsthumbnail", files...).CombinedOutput()
 	if err != nil {
-		err = fmt.Errorf("vipsthumbnail failed: %s : %s", err.Error(), out)
+		monitoring.ExecFailed("vipsthumbnail")
+		return fmt.Errorf("vipsthumbnail failed: %s : %s", err.Error(), out)
+	}
+
+	if placement != nil {
+		for _, frame := range frames {
+			if err := placement.Place(path.Join(dir, "frames", name, frame)); err != nil {
+				return fmt.Errorf("place failed: %s", err.Error())
+			}
+		}
 	}
 
 	for _, file := range files[4:] {
 		out, err = exec.CommandContext(ctx, "optipng", "-o7", file).CombinedOutput()
 		if err != nil {
+			monitoring.ExecFailed("optipng")
 			return fmt.Errorf("optipng failed: %s %s", err.Error(), out)
 		}
 	}
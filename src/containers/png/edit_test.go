@@ -0,0 +1,35 @@
+package png
+
+import (
+	"image"
+	"image/color"
+	nPng "image/png"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Place(t *testing.T) {
+	file := path.Join(t.TempDir(), "frame.png")
+
+	frame := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	frame.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+	f, _ := os.Create(file)
+	_ = nPng.Encode(f, frame)
+	_ = f.Close()
+
+	background := color.NRGBA{0, 0, 255, 255}
+	err := Placement{Width: 4, Height: 2, X: 0.5, Background: background}.Place(file)
+	assert.ErrorIs(t, err, nil, "no error when placing")
+
+	f, _ = os.Open(file)
+	defer f.Close()
+	img, err := nPng.Decode(f)
+	assert.ErrorIs(t, err, nil, "The placed frame is a png")
+
+	assert.Equal(t, background, color.NRGBAModel.Convert(img.At(0, 0)), "The padding has the background")
+	assert.Equal(t, color.NRGBA{255, 0, 0, 255}, color.NRGBAModel.Convert(img.At(1, 0)), "The frame is placed in the middle")
+	assert.Equal(t, color.NRGBA{}, color.NRGBAModel.Convert(img.At(2, 1)), "Transparent pixels of the frame stay transparent")
+}
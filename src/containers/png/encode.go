@@ -4,18 +4,36 @@ import (
 	"context"
 	"fmt"
 	"os/exec"
+	"path"
+
+	"github.com/seventv/ImageProcessor/src/monitoring"
 )
 
 func Encode(ctx context.Context, input string, output string) error {
 	out, err := exec.CommandContext(ctx, "cp", input, output).CombinedOutput()
 	if err != nil {
+		monitoring.ExecFailed("cp")
 		return fmt.Errorf("cp failed: %s %s", err.Error(), out)
 	}
 
 	out, err = exec.CommandContext(ctx, "optipng", "-o7", output).CombinedOutput()
 	if err != nil {
+		monitoring.ExecFailed("optipng")
 		return fmt.Errorf("optipng failed: %s %s", err.Error(), out)
 	}
 
 	return nil
 }
+
+func EncodeAnimation(ctx context.Context, name string, outName string, dir string, frames []string, delays []int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	newFrames := make([]string, len(frames))
+	for i, v := range frames {
+		newFrames[i] = path.Join(dir, "frames", name, v)
+	}
+
+	return WriteAnimation(path.Join(dir, fmt.Sprintf("%s.png", outName)), newFrames, delays)
+}
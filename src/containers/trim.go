@@ -0,0 +1,112 @@
+package containers
+
+import (
+	"fmt"
+	nImage "image"
+	"image/color"
+	nPng "image/png"
+	"os"
+	"path"
+
+	"github.com/seventv/ImageProcessor/src/image"
+	"github.com/seventv/ImageProcessor/src/job"
+)
+
+type subImager interface {
+	SubImage(r nImage.Rectangle) nImage.Image
+}
+
+func decodeFrame(file string) (nImage.Image, error) {
+	f, err := os.OpenFile(file, os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open file failed: %s", err.Error())
+	}
+	defer f.Close()
+
+	return nPng.Decode(f)
+}
+
+// contentBounds is the smallest rectangle holding every pixel with an alpha above the threshold, it is empty when there are none.
+func contentBounds(img nImage.Image, threshold uint8) nImage.Rectangle {
+	b := img.Bounds()
+	out := nImage.Rectangle{}
+
+	alpha := func(x, y int) uint8 {
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA).A
+	}
+	if nrgba, ok := img.(*nImage.NRGBA); ok {
+		alpha = func(x, y int) uint8 {
+			return nrgba.Pix[nrgba.PixOffset(x, y)+3]
+		}
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if alpha(x, y) > threshold {
+				out = out.Union(nImage.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+
+	return out
+}
+
+// trimFrames crops every frame to the union of their content bounds plus the margin.
+// Nothing is cropped when the frames have no content or no border to remove.
+func trimFrames(frameDir string, frameCount int, trim job.Trim) (*image.Rect, error) {
+	frames := make([]string, frameCount)
+	for i := range frames {
+		frames[i] = path.Join(frameDir, fmt.Sprintf("dump_%04d.png", i))
+	}
+
+	canvas := nImage.Rectangle{}
+	bounds := nImage.Rectangle{}
+	for _, frame := range frames {
+		img, err := decodeFrame(frame)
+		if err != nil {
+			return nil, err
+		}
+
+		canvas = canvas.Union(img.Bounds())
+		bounds = bounds.Union(contentBounds(img, trim.AlphaThreshold))
+	}
+
+	if bounds.Empty() {
+		bounds = canvas
+	}
+
+	bounds = bounds.Inset(-trim.Margin).Intersect(canvas)
+
+	if bounds != canvas {
+		enc := nPng.Encoder{CompressionLevel: nPng.BestSpeed}
+		for _, frame := range frames {
+			img, err := decodeFrame(frame)
+			if err != nil {
+				return nil, err
+			}
+
+			sub, ok := img.(subImager)
+			if !ok {
+				return nil, fmt.Errorf("trim failed: %T cannot be cropped", img)
+			}
+
+			f, err := os.OpenFile(frame, os.O_TRUNC|os.O_WRONLY, 0600)
+			if err != nil {
+				return nil, fmt.Errorf("open file failed: %s", err.Error())
+			}
+
+			err = enc.Encode(f, sub.SubImage(bounds))
+			_ = f.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &image.Rect{
+		X:      bounds.Min.X - canvas.Min.X,
+		Y:      bounds.Min.Y - canvas.Min.Y,
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+	}, nil
+}
@@ -5,35 +5,75 @@ import (
 	"fmt"
 	"os/exec"
 	"path"
+	"strconv"
+
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/seventv/ImageProcessor/src/monitoring"
 )
 
-func Encode(ctx context.Context, name string, outName string, dir string, frames []string, delays []int) error {
+func Encode(ctx context.Context, name string, outName string, dir string, frames []string, delays []int, opts job.EncoderOptions) error {
 	webpFile := path.Join(dir, fmt.Sprintf("%s.webp", outName))
 
+	lossless := opts.Lossless == nil || *opts.Lossless
+
 	if len(delays) == 1 {
-		out, err := exec.CommandContext(ctx, "cwebp", "-z", "5", "-preset", "icon", "-sharpness", "3", path.Join(dir, "frames", name, frames[0]), "-o", webpFile).CombinedOutput()
+		args := []string{"-preset", "icon", "-sharpness", "3"}
+		if lossless {
+			// -z is a lossless preset which overrides the quality and method so it is only used when neither was asked for.
+			if opts.Quality == nil && opts.Effort == nil {
+				args = append(args, "-z", "5")
+			} else {
+				args = append(args, "-lossless")
+			}
+		}
+		if opts.Quality != nil {
+			args = append(args, "-q", strconv.Itoa(*opts.Quality))
+		}
+		if opts.Effort != nil {
+			args = append(args, "-m", strconv.Itoa(*opts.Effort))
+		}
+		if opts.AlphaQuality != nil {
+			args = append(args, "-alpha_q", strconv.Itoa(*opts.AlphaQuality))
+		}
+		args = append(args, path.Join(dir, "frames", name, frames[0]), "-o", webpFile)
+
+		out, err := exec.CommandContext(ctx, "cwebp", args...).CombinedOutput()
 		if err != nil {
+			monitoring.ExecFailed("cwebp")
 			err = fmt.Errorf("cwebp failed: %s : %s", err.Error(), out)
 		}
 
 		return err
 	}
 
-	const argOffset = 11
-	args := make([]string, len(delays)*3+argOffset)
-	args[0] = "-o"
-	args[1] = webpFile
-	args[2] = "-loop"
-	args[3] = "0"
-	args[4] = "-lossless"
+	args := []string{
+		"-o", webpFile,
+		"-loop", "0",
+	}
+	if opts.KeyframeInterval != nil {
+		args = append(args, "-kmax", strconv.Itoa(*opts.KeyframeInterval))
+	}
+
+	// the frame options apply to every frame after them.
+	if lossless {
+		args = append(args, "-lossless")
+	} else {
+		args = append(args, "-lossy")
+	}
+	if opts.Quality != nil {
+		args = append(args, "-q", strconv.Itoa(*opts.Quality))
+	}
+	if opts.Effort != nil {
+		args = append(args, "-m", strconv.Itoa(*opts.Effort))
+	}
+
 	for i, v := range delays {
-		args[argOffset+i*3] = "-d"
-		args[argOffset+i*3+1] = fmt.Sprint(v * 10)
-		args[argOffset+i*3+2] = path.Join(dir, "frames", name, frames[i])
+		args = append(args, "-d", fmt.Sprint(v), path.Join(dir, "frames", name, frames[i]))
 	}
 
 	out, err := exec.CommandContext(ctx, "img2webp", args...).CombinedOutput()
 	if err != nil {
+		monitoring.ExecFailed("img2webp")
 		err = fmt.Errorf("img2webp failed: %s : %s", err.Error(), out)
 	}
 
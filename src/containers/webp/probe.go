@@ -0,0 +1,231 @@
+package webp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+var (
+	ErrBadRiff  = fmt.Errorf("bad riff container")
+	ErrBadChunk = fmt.Errorf("bad webp chunk")
+	ErrNoFrames = fmt.Errorf("webp has no frames")
+)
+
+// VP8X flags
+// https://developers.google.com/speed/webp/docs/riff_container#extended_file_format
+const (
+	vp8xAnimation = 1 << 1
+	vp8xAlpha     = 1 << 4
+)
+
+// ANMF flags
+const (
+	anmfDispose  = 1 << 0
+	anmfNoBlend  = 1 << 1
+	anmfMinBytes = 16
+)
+
+type Info struct {
+	Width    int
+	Height   int
+	Animated bool
+	Alpha    bool
+	// LoopCount is the number of times the animation loops, 0 means forever.
+	LoopCount int
+	Frames    []Frame
+}
+
+type Frame struct {
+	X      int
+	Y      int
+	Width  int
+	Height int
+	// Duration in milliseconds.
+	Duration int
+	// Blend is true when the frame is alpha blended onto the canvas, otherwise it replaces the pixels it covers.
+	Blend bool
+	// Dispose is true when the area of the frame is cleared to the background colour before the next frame.
+	Dispose bool
+	Alpha   bool
+}
+
+type chunk struct {
+	fourCC string
+	data   []byte
+}
+
+// chunks splits a run of RIFF chunks, every chunk is padded to an even length.
+func chunks(data []byte) ([]chunk, error) {
+	out := []chunk{}
+	for len(data) != 0 {
+		if len(data) < 8 {
+			return nil, ErrBadChunk
+		}
+
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if size < 0 || size > len(data)-8 {
+			return nil, ErrBadChunk
+		}
+
+		out = append(out, chunk{
+			fourCC: string(data[0:4]),
+			data:   data[8 : 8+size],
+		})
+
+		size += size & 1
+		if size > len(data)-8 {
+			// some encoders drop the padding byte on the last chunk.
+			break
+		}
+		data = data[8+size:]
+	}
+
+	return out, nil
+}
+
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+// bitstream reads the dimensions and alpha of a VP8 or VP8L bitstream chunk.
+func bitstream(c chunk) (width int, height int, alpha bool, err error) {
+	switch c.fourCC {
+	case "VP8 ":
+		// 3 byte frame tag, 3 byte start code and then 14 bit dimensions
+		// https://datatracker.ietf.org/doc/html/rfc6386#section-9.1
+		if len(c.data) < 10 || c.data[3] != 0x9d || c.data[4] != 0x01 || c.data[5] != 0x2a {
+			return 0, 0, false, ErrBadChunk
+		}
+		width = int(binary.LittleEndian.Uint16(c.data[6:8]) & 0x3fff)
+		height = int(binary.LittleEndian.Uint16(c.data[8:10]) & 0x3fff)
+		return width, height, false, nil
+	case "VP8L":
+		// https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification#3_riff_header
+		if len(c.data) < 5 || c.data[0] != 0x2f {
+			return 0, 0, false, ErrBadChunk
+		}
+		bits := binary.LittleEndian.Uint32(c.data[1:5])
+		width = int(bits&0x3fff) + 1
+		height = int((bits>>14)&0x3fff) + 1
+		alpha = (bits>>28)&1 == 1
+		return width, height, alpha, nil
+	}
+
+	return 0, 0, false, ErrBadChunk
+}
+
+// frameData reads the image chunks of a still image or of an ANMF payload.
+func frameData(cs []chunk) (width int, height int, alpha bool, err error) {
+	for _, c := range cs {
+		switch c.fourCC {
+		case "ALPH":
+			alpha = true
+		case "VP8 ", "VP8L":
+			w, h, a, err := bitstream(c)
+			return w, h, alpha || a, err
+		}
+	}
+
+	return 0, 0, false, ErrNoFrames
+}
+
+// Probe reads the canvas, loop count and per frame timing of a WEBP without decoding any of the frames.
+func Probe(data []byte) (*Info, error) {
+	if !Test(data) {
+		return nil, ErrBadRiff
+	}
+
+	size := int(binary.LittleEndian.Uint32(data[4:8]))
+	if size < 4 || size > len(data)-8 {
+		return nil, ErrBadRiff
+	}
+
+	cs, err := chunks(data[12 : 8+size])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cs) == 0 {
+		return nil, ErrNoFrames
+	}
+
+	info := &Info{}
+
+	if cs[0].fourCC != "VP8X" {
+		// simple file format, a single lossy or lossless frame.
+		w, h, a, err := frameData(cs)
+		if err != nil {
+			return nil, err
+		}
+
+		info.Width = w
+		info.Height = h
+		info.Alpha = a
+		info.Frames = []Frame{{Width: w, Height: h, Alpha: a}}
+
+		return info, nil
+	}
+
+	vp8x := cs[0].data
+	if len(vp8x) < 10 {
+		return nil, ErrBadChunk
+	}
+
+	info.Animated = vp8x[0]&vp8xAnimation != 0
+	info.Alpha = vp8x[0]&vp8xAlpha != 0
+	info.Width = uint24(vp8x[4:7]) + 1
+	info.Height = uint24(vp8x[7:10]) + 1
+
+	if !info.Animated {
+		w, h, a, err := frameData(cs[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		info.Alpha = info.Alpha || a
+		info.Frames = []Frame{{Width: w, Height: h, Alpha: a}}
+
+		return info, nil
+	}
+
+	for _, c := range cs[1:] {
+		switch c.fourCC {
+		case "ANIM":
+			if len(c.data) < 6 {
+				return nil, ErrBadChunk
+			}
+			info.LoopCount = int(binary.LittleEndian.Uint16(c.data[4:6]))
+		case "ANMF":
+			if len(c.data) < anmfMinBytes {
+				return nil, ErrBadChunk
+			}
+
+			sub, err := chunks(c.data[anmfMinBytes:])
+			if err != nil {
+				return nil, err
+			}
+
+			_, _, a, err := frameData(sub)
+			if err != nil {
+				return nil, err
+			}
+
+			info.Frames = append(info.Frames, Frame{
+				X:        uint24(c.data[0:3]) * 2,
+				Y:        uint24(c.data[3:6]) * 2,
+				Width:    uint24(c.data[6:9]) + 1,
+				Height:   uint24(c.data[9:12]) + 1,
+				Duration: uint24(c.data[12:15]),
+				Blend:    c.data[15]&anmfNoBlend == 0,
+				Dispose:  c.data[15]&anmfDispose != 0,
+				Alpha:    a,
+			})
+		}
+	}
+
+	if len(info.Frames) == 0 {
+		return nil, ErrNoFrames
+	}
+
+	return info, nil
+}
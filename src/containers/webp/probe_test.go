@@ -0,0 +1,122 @@
+package webp
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testChunk(fourCC string, data []byte) []byte {
+	out := make([]byte, 8, 8+len(data)+1)
+	copy(out, fourCC)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(data)))
+	out = append(out, data...)
+	if len(data)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+func testRiff(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	out := make([]byte, 8, 8+len(body))
+	copy(out, "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(body)))
+	return append(out, body...)
+}
+
+func testUint24(v int) []byte {
+	return []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+}
+
+// a lossless bitstream header, signature then 14 bit width-1, 14 bit height-1, alpha bit and version.
+func testVP8L(w, h int, alpha bool) []byte {
+	bits := uint32(w-1) | uint32(h-1)<<14
+	if alpha {
+		bits |= 1 << 28
+	}
+	out := []byte{0x2f, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(out[1:], bits)
+	return testChunk("VP8L", out)
+}
+
+// a lossy bitstream header, frame tag, start code and 14 bit dimensions.
+func testVP8(w, h int) []byte {
+	out := []byte{0, 0, 0, 0x9d, 0x01, 0x2a, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(out[6:], uint16(w))
+	binary.LittleEndian.PutUint16(out[8:], uint16(h))
+	return testChunk("VP8 ", out)
+}
+
+func testANMF(x, y, w, h, duration int, flags byte, frame []byte) []byte {
+	data := []byte{}
+	data = append(data, testUint24(x/2)...)
+	data = append(data, testUint24(y/2)...)
+	data = append(data, testUint24(w-1)...)
+	data = append(data, testUint24(h-1)...)
+	data = append(data, testUint24(duration)...)
+	data = append(data, flags)
+	return testChunk("ANMF", append(data, frame...))
+}
+
+func Test_ProbeStatic(t *testing.T) {
+	info, err := Probe(testRiff(testVP8(32, 16)))
+	assert.ErrorIs(t, err, nil, "lossy webp")
+	assert.Equal(t, 32, info.Width)
+	assert.Equal(t, 16, info.Height)
+	assert.False(t, info.Alpha)
+	assert.False(t, info.Animated)
+	assert.Len(t, info.Frames, 1)
+
+	info, err = Probe(testRiff(testVP8L(7, 9, true)))
+	assert.ErrorIs(t, err, nil, "lossless webp")
+	assert.Equal(t, 7, info.Width)
+	assert.Equal(t, 9, info.Height)
+	assert.True(t, info.Alpha)
+
+	vp8x := append([]byte{vp8xAlpha, 0, 0, 0}, append(testUint24(31), testUint24(15)...)...)
+	info, err = Probe(testRiff(testChunk("VP8X", vp8x), testChunk("ALPH", []byte{0}), testVP8(32, 16)))
+	assert.ErrorIs(t, err, nil, "extended webp")
+	assert.Equal(t, 32, info.Width)
+	assert.True(t, info.Alpha)
+	assert.True(t, info.Frames[0].Alpha)
+}
+
+func Test_ProbeAnimated(t *testing.T) {
+	vp8x := append([]byte{vp8xAnimation | vp8xAlpha, 0, 0, 0}, append(testUint24(63), testUint24(31)...)...)
+	data := testRiff(
+		testChunk("VP8X", vp8x),
+		testChunk("ANIM", []byte{0, 0, 0, 0, 3, 0}),
+		testANMF(0, 0, 64, 32, 125, 0, testVP8L(64, 32, true)),
+		testANMF(10, 4, 21, 11, 33, anmfNoBlend|anmfDispose, append(testChunk("ALPH", []byte{0}), testVP8(21, 11)...)),
+	)
+
+	info, err := Probe(data)
+	assert.ErrorIs(t, err, nil, "animated webp")
+	assert.True(t, info.Animated)
+	assert.True(t, info.Alpha)
+	assert.Equal(t, 64, info.Width)
+	assert.Equal(t, 32, info.Height)
+	assert.Equal(t, 3, info.LoopCount)
+	assert.Equal(t, []Frame{
+		{X: 0, Y: 0, Width: 64, Height: 32, Duration: 125, Blend: true, Dispose: false, Alpha: true},
+		{X: 10, Y: 4, Width: 21, Height: 11, Duration: 33, Blend: false, Dispose: true, Alpha: true},
+	}, info.Frames)
+}
+
+func Test_ProbeErrors(t *testing.T) {
+	_, err := Probe([]byte("RIFF\x00\x00\x00\x00WAVE"))
+	assert.ErrorIs(t, err, ErrBadRiff, "not a webp")
+
+	data := testRiff(testVP8(32, 16))
+	_, err = Probe(data[:len(data)-4])
+	assert.ErrorIs(t, err, ErrBadRiff, "truncated riff")
+
+	vp8x := append([]byte{vp8xAnimation, 0, 0, 0}, append(testUint24(63), testUint24(31)...)...)
+	_, err = Probe(testRiff(testChunk("VP8X", vp8x), testChunk("ANIM", []byte{0, 0, 0, 0, 0, 0})))
+	assert.ErrorIs(t, err, ErrNoFrames, "animation without frames")
+}
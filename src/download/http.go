@@ -0,0 +1,172 @@
+package download
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/seventv/ImageProcessor/src/configure"
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultMaxSize      int64 = 50 * 1024 * 1024
+	DefaultMaxRedirects       = 5
+	DefaultTimeout            = time.Minute
+)
+
+var (
+	ErrBadURL            = fmt.Errorf("bad url")
+	ErrTooLarge          = fmt.Errorf("body exceeds the max size")
+	ErrTooManyRedirects  = fmt.Errorf("too many redirects")
+	ErrSizeMismatch      = fmt.Errorf("body size does not match the expected size")
+	ErrChecksumMismatch  = fmt.Errorf("body sha256 does not match the expected sha256")
+	ErrUnexpectedStatus  = fmt.Errorf("unexpected status code")
+	ErrClientStatusError = fmt.Errorf("client error")
+	ErrServerStatusError = fmt.Errorf("server error")
+)
+
+// StatusError is returned when the remote responds with anything other than a 2xx.
+// It matches ErrClientStatusError for 4xx and ErrServerStatusError for 5xx responses.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s responded with %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrClientStatusError:
+		return e.StatusCode >= 400 && e.StatusCode < 500
+	case ErrServerStatusError:
+		return e.StatusCode >= 500
+	case ErrUnexpectedStatus:
+		return true
+	}
+
+	return false
+}
+
+type Options struct {
+	MaxSize      int64
+	MaxRedirects int
+	Timeout      time.Duration
+}
+
+func OptionsFromConfig(config *configure.Config) Options {
+	opts := Options{
+		MaxSize:      config.Http.MaxSize,
+		MaxRedirects: config.Http.MaxRedirects,
+		Timeout:      time.Second * time.Duration(config.Http.Timeout),
+	}
+
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+	if opts.MaxRedirects <= 0 {
+		opts.MaxRedirects = DefaultMaxRedirects
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	return opts
+}
+
+// Http streams the body found at details.URL into file.
+// The body is never held in memory, it is written to disk as it arrives and verified against the expected size and sha256 when they are provided.
+func Http(ctx context.Context, opts Options, details job.RawProviderDetailsHttp, file string) error {
+	if !strings.HasPrefix(details.URL, "http://") && !strings.HasPrefix(details.URL, "https://") {
+		return ErrBadURL
+	}
+
+	maxSize := opts.MaxSize
+	if details.Size > 0 && details.Size < maxSize {
+		maxSize = details.Size
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > opts.MaxRedirects {
+				return ErrTooManyRedirects
+			}
+			return nil
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, details.URL, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBadURL, err.Error())
+	}
+
+	for k, v := range details.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{
+			URL:        details.URL,
+			StatusCode: resp.StatusCode,
+		}
+	}
+
+	if details.Size > 0 && resp.ContentLength >= 0 && resp.ContentLength != details.Size {
+		return ErrSizeMismatch
+	}
+
+	if resp.ContentLength > maxSize {
+		return ErrTooLarge
+	}
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+
+	// read one byte past the limit so we can tell when the body is too large.
+	n, err := io.Copy(io.MultiWriter(f, hash), io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return err
+	}
+
+	if n > maxSize {
+		if details.Size > 0 && details.Size < opts.MaxSize {
+			return ErrSizeMismatch
+		}
+		return ErrTooLarge
+	}
+
+	if details.Size > 0 && n != details.Size {
+		return ErrSizeMismatch
+	}
+
+	if details.Sha256 != "" && !strings.EqualFold(details.Sha256, hex.EncodeToString(hash.Sum(nil))) {
+		return ErrChecksumMismatch
+	}
+
+	logrus.Debugf("%d bytes downloaded from %s", n, details.URL)
+
+	return f.Close()
+}
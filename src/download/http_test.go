@@ -0,0 +1,121 @@
+package download
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/stretchr/testify/assert"
+)
+
+func testServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+			return
+		}
+		_, _ = w.Write([]byte("hello world"))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/redirect", http.StatusFound)
+	})
+	mux.HandleFunc("/not-found", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second * 5):
+		case <-r.Context().Done():
+		}
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func testOptions() Options {
+	return Options{
+		MaxSize:      1024,
+		MaxRedirects: 3,
+		Timeout:      time.Second,
+	}
+}
+
+func Test_Http(t *testing.T) {
+	srv := testServer()
+	defer srv.Close()
+
+	file := path.Join(t.TempDir(), "raw")
+
+	hash := sha256.Sum256([]byte("hello world"))
+
+	err := Http(context.Background(), testOptions(), job.RawProviderDetailsHttp{
+		URL:    srv.URL + "/ok",
+		Size:   11,
+		Sha256: hex.EncodeToString(hash[:]),
+	}, file)
+	assert.ErrorIs(t, err, nil, "no error when downloading the file")
+
+	data, err := os.ReadFile(file)
+	assert.ErrorIs(t, err, nil, "no error when reading the file")
+	assert.Equal(t, "hello world", string(data), "The body was written to disk")
+
+	err = Http(context.Background(), testOptions(), job.RawProviderDetailsHttp{
+		URL: srv.URL + "/ok",
+		Headers: map[string]string{
+			"Authorization": "Bearer pogu",
+		},
+	}, file)
+	assert.ErrorIs(t, err, nil, "no error when downloading the file")
+
+	data, _ = os.ReadFile(file)
+	assert.Equal(t, "Bearer pogu", string(data), "The headers were sent")
+}
+
+func Test_HttpErrors(t *testing.T) {
+	srv := testServer()
+	defer srv.Close()
+
+	file := path.Join(t.TempDir(), "raw")
+
+	smallOpts := testOptions()
+	smallOpts.MaxSize = 5
+
+	tests := []struct {
+		name    string
+		opts    Options
+		details job.RawProviderDetailsHttp
+		err     error
+	}{
+		{"bad url", testOptions(), job.RawProviderDetailsHttp{URL: "ftp://example.com"}, ErrBadURL},
+		{"not found", testOptions(), job.RawProviderDetailsHttp{URL: srv.URL + "/not-found"}, ErrClientStatusError},
+		{"bad gateway", testOptions(), job.RawProviderDetailsHttp{URL: srv.URL + "/broken"}, ErrServerStatusError},
+		{"redirect loop", testOptions(), job.RawProviderDetailsHttp{URL: srv.URL + "/redirect"}, ErrTooManyRedirects},
+		{"too large", smallOpts, job.RawProviderDetailsHttp{URL: srv.URL + "/ok"}, ErrTooLarge},
+		{"size mismatch", testOptions(), job.RawProviderDetailsHttp{URL: srv.URL + "/ok", Size: 5}, ErrSizeMismatch},
+		{"checksum mismatch", testOptions(), job.RawProviderDetailsHttp{URL: srv.URL + "/ok", Sha256: "00"}, ErrChecksumMismatch},
+		{"timeout", testOptions(), job.RawProviderDetailsHttp{URL: srv.URL + "/slow"}, context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		err := Http(context.Background(), test.opts, test.details, file)
+		assert.True(t, errors.Is(err, test.err), "%s: expected %v got %v", test.name, test.err, err)
+	}
+
+	err := Http(context.Background(), testOptions(), job.RawProviderDetailsHttp{URL: srv.URL + "/not-found"}, file)
+	statusErr := &StatusError{}
+	assert.True(t, errors.As(err, &statusErr), "The error is a status error")
+	assert.Equal(t, http.StatusNotFound, statusErr.StatusCode, "The status code is reported")
+	assert.False(t, errors.Is(err, ErrServerStatusError), "A 4xx is not a server error")
+}
@@ -15,10 +15,20 @@ type Instances struct {
 type AwsS3 interface {
 	UploadFile(ctx context.Context, bucket, key string, data io.Reader, contentType, acl, cacheControl *string) error
 	DownloadFile(ctx context.Context, bucket, key string, file io.WriterAt) error
+	// ObjectSize is the size of the object in bytes without downloading it.
+	ObjectSize(ctx context.Context, bucket, key string) (int64, error)
+	HeadBucket(ctx context.Context, bucket string) error
 }
 
 type Rmq interface {
 	Subscribe(name string) (<-chan amqp.Delivery, error)
+	// SubscribeFanout receives every message published to the fanout exchange.
+	SubscribeFanout(exchange string) (<-chan amqp.Delivery, error)
 	Publish(queue string, contentType string, deliveryMode uint8, msg []byte) error
+	// PublishMessage publishes msg as is, for messages that need headers.
+	PublishMessage(queue string, msg amqp.Publishing) error
+	// Current reports if the delivery can still be settled, deliveries received before a reconnect cannot be.
+	Current(msg amqp.Delivery) bool
+	Healthy() error
 	Shutdown()
 }
@@ -4,8 +4,20 @@ type Image struct {
 	Dir    string
 	Width  uint16
 	Height uint16
+	// Delays of each frame in milliseconds.
 	Delays []int
 	Frames []string
+	// Trim is the box the frames were cropped to, relative to the source frames, or nil when they were not trimmed.
+	Trim *Rect
+	// Threads is how many threads the job may use, 0 leaves it to each tool.
+	Threads int
+}
+
+type Rect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
 }
 
 type ImageType string
@@ -15,6 +27,7 @@ const (
 	AVIF ImageType = "avif"
 	FLV  ImageType = "flv"
 	GIF  ImageType = "gif"
+	HEIF ImageType = "heif"
 	JPEG ImageType = "jpeg"
 	MP4  ImageType = "mp4"
 	PNG  ImageType = "png"
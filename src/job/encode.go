@@ -0,0 +1,168 @@
+package job
+
+import "fmt"
+
+var (
+	ErrBadEncodeOptions = fmt.Errorf("bad encode options")
+	ErrOverBudget       = fmt.Errorf("output exceeds max bytes")
+)
+
+// BudgetError is returned when an output cannot be made to fit within its max bytes, it matches ErrOverBudget.
+type BudgetError struct {
+	Name     string
+	MaxBytes int64
+	// Size is the smallest the output could be made.
+	Size int64
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s: %s is %d bytes at the lowest quality which is more than %d", ErrOverBudget.Error(), e.Name, e.Size, e.MaxBytes)
+}
+
+func (e *BudgetError) Is(target error) bool {
+	return target == ErrOverBudget
+}
+
+// EncodeOptions tunes each of the encoders, anything left out falls back to the defaults in the config and then to the encoder's own defaults.
+type EncodeOptions struct {
+	AVIF EncoderOptions `json:"avif" mapstructure:"avif"`
+	WEBP EncoderOptions `json:"webp" mapstructure:"webp"`
+	GIF  EncoderOptions `json:"gif" mapstructure:"gif"`
+	JXL  EncoderOptions `json:"jxl" mapstructure:"jxl"`
+}
+
+type EncoderOptions struct {
+	// Quality from 0 to 100, higher is better.
+	Quality *int `json:"quality,omitempty" mapstructure:"quality,omitempty"`
+	// Effort trades encoding time for smaller files, higher is slower. The range depends on the format.
+	Effort   *int  `json:"effort,omitempty" mapstructure:"effort,omitempty"`
+	Lossless *bool `json:"lossless,omitempty" mapstructure:"lossless,omitempty"`
+	// AlphaQuality from 0 to 100, higher is better.
+	AlphaQuality *int `json:"alpha_quality,omitempty" mapstructure:"alpha_quality,omitempty"`
+	// KeyframeInterval is the max number of frames between keyframes.
+	KeyframeInterval *int `json:"keyframe_interval,omitempty" mapstructure:"keyframe_interval,omitempty"`
+	// MaxBytes is the largest an output may be, the quality is lowered until it fits. 0 means no limit.
+	MaxBytes int64 `json:"max_bytes,omitempty" mapstructure:"max_bytes,omitempty"`
+	// SizeMaxBytes overrides MaxBytes for individual sizes by name.
+	SizeMaxBytes map[string]int64 `json:"size_max_bytes,omitempty" mapstructure:"size_max_bytes,omitempty"`
+}
+
+// MaxBytesFor is the max bytes of the named size, 0 means no limit.
+func (o EncoderOptions) MaxBytesFor(size string) int64 {
+	if v, ok := o.SizeMaxBytes[size]; ok {
+		return v
+	}
+
+	return o.MaxBytes
+}
+
+// encoderSupport is what each encoder understands, options it does not understand are rejected rather than ignored.
+type encoderSupport struct {
+	// minQuality is the lowest quality the encoder accepts, the highest is always 100.
+	minQuality       int
+	minEffort        int
+	maxEffort        int
+	effort           bool
+	lossless         bool
+	alphaQuality     bool
+	keyframeInterval bool
+}
+
+func (o EncoderOptions) validate(format string, support encoderSupport) error {
+	if o.Quality != nil && (*o.Quality < support.minQuality || *o.Quality > 100) {
+		return fmt.Errorf("%w: %s quality must be between %d and 100", ErrBadEncodeOptions, format, support.minQuality)
+	}
+
+	if o.Effort != nil {
+		if !support.effort {
+			return fmt.Errorf("%w: %s does not support effort", ErrBadEncodeOptions, format)
+		}
+		if *o.Effort < support.minEffort || *o.Effort > support.maxEffort {
+			return fmt.Errorf("%w: %s effort must be between %d and %d", ErrBadEncodeOptions, format, support.minEffort, support.maxEffort)
+		}
+	}
+
+	if o.Lossless != nil && !support.lossless {
+		return fmt.Errorf("%w: %s does not support lossless", ErrBadEncodeOptions, format)
+	}
+
+	if o.AlphaQuality != nil {
+		if !support.alphaQuality {
+			return fmt.Errorf("%w: %s does not support alpha quality", ErrBadEncodeOptions, format)
+		}
+		if *o.AlphaQuality < 0 || *o.AlphaQuality > 100 {
+			return fmt.Errorf("%w: %s alpha quality must be between 0 and 100", ErrBadEncodeOptions, format)
+		}
+	}
+
+	if o.MaxBytes < 0 {
+		return fmt.Errorf("%w: %s max bytes must not be negative", ErrBadEncodeOptions, format)
+	}
+
+	for size, v := range o.SizeMaxBytes {
+		if v < 0 {
+			return fmt.Errorf("%w: %s max bytes of %s must not be negative", ErrBadEncodeOptions, format, size)
+		}
+	}
+
+	if o.KeyframeInterval != nil {
+		if !support.keyframeInterval {
+			return fmt.Errorf("%w: %s does not support a keyframe interval", ErrBadEncodeOptions, format)
+		}
+		if *o.KeyframeInterval < 0 {
+			return fmt.Errorf("%w: %s keyframe interval must not be negative", ErrBadEncodeOptions, format)
+		}
+	}
+
+	return nil
+}
+
+func (o EncoderOptions) withDefaults(def EncoderOptions) EncoderOptions {
+	if o.Quality == nil {
+		o.Quality = def.Quality
+	}
+	if o.Effort == nil {
+		o.Effort = def.Effort
+	}
+	if o.Lossless == nil {
+		o.Lossless = def.Lossless
+	}
+	if o.AlphaQuality == nil {
+		o.AlphaQuality = def.AlphaQuality
+	}
+	if o.KeyframeInterval == nil {
+		o.KeyframeInterval = def.KeyframeInterval
+	}
+	if o.MaxBytes == 0 {
+		o.MaxBytes = def.MaxBytes
+	}
+	if o.SizeMaxBytes == nil {
+		o.SizeMaxBytes = def.SizeMaxBytes
+	}
+
+	return o
+}
+
+func (o EncodeOptions) Validate() error {
+	if err := o.AVIF.validate("avif", encoderSupport{effort: true, maxEffort: 10, lossless: true, alphaQuality: true, keyframeInterval: true}); err != nil {
+		return err
+	}
+	if err := o.WEBP.validate("webp", encoderSupport{effort: true, maxEffort: 6, lossless: true, alphaQuality: true, keyframeInterval: true}); err != nil {
+		return err
+	}
+	if err := o.GIF.validate("gif", encoderSupport{minQuality: 1}); err != nil {
+		return err
+	}
+
+	return o.JXL.validate("jxl", encoderSupport{effort: true, minEffort: 1, maxEffort: 9, lossless: true})
+}
+
+// WithDefaults fills in every option which was not set from def.
+func (o EncodeOptions) WithDefaults(def EncodeOptions) EncodeOptions {
+	return EncodeOptions{
+		AVIF: o.AVIF.withDefaults(def.AVIF),
+		WEBP: o.WEBP.withDefaults(def.WEBP),
+		GIF:  o.GIF.withDefaults(def.GIF),
+		JXL:  o.JXL.withDefaults(def.JXL),
+	}
+}
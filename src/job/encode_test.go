@@ -0,0 +1,60 @@
+package job
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func intPointer(v int) *int {
+	return &v
+}
+
+func boolPointer(v bool) *bool {
+	return &v
+}
+
+func Test_EncodeOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  EncodeOptions
+		valid bool
+	}{
+		{"empty", EncodeOptions{}, true},
+		{"avif", EncodeOptions{AVIF: EncoderOptions{Quality: intPointer(80), Effort: intPointer(10), AlphaQuality: intPointer(90), KeyframeInterval: intPointer(30)}}, true},
+		{"webp lossless", EncodeOptions{WEBP: EncoderOptions{Lossless: boolPointer(true), Effort: intPointer(6)}}, true},
+		{"jxl", EncodeOptions{JXL: EncoderOptions{Effort: intPointer(1), Quality: intPointer(0)}}, true},
+		{"quality too high", EncodeOptions{AVIF: EncoderOptions{Quality: intPointer(101)}}, false},
+		{"webp effort too high", EncodeOptions{WEBP: EncoderOptions{Effort: intPointer(7)}}, false},
+		{"jxl effort too low", EncodeOptions{JXL: EncoderOptions{Effort: intPointer(0)}}, false},
+		{"gif lossless", EncodeOptions{GIF: EncoderOptions{Lossless: boolPointer(true)}}, false},
+		{"gif quality 0", EncodeOptions{GIF: EncoderOptions{Quality: intPointer(0)}}, false},
+		{"webp quality 0", EncodeOptions{WEBP: EncoderOptions{Quality: intPointer(0)}}, true},
+		{"jxl keyframes", EncodeOptions{JXL: EncoderOptions{KeyframeInterval: intPointer(10)}}, false},
+		{"negative keyframes", EncodeOptions{AVIF: EncoderOptions{KeyframeInterval: intPointer(-1)}}, false},
+	}
+
+	for _, test := range tests {
+		err := test.opts.Validate()
+		if test.valid {
+			assert.ErrorIs(t, err, nil, test.name)
+		} else {
+			assert.True(t, errors.Is(err, ErrBadEncodeOptions), "%s: expected a bad encode options error got %v", test.name, err)
+		}
+	}
+}
+
+func Test_EncodeOptionsWithDefaults(t *testing.T) {
+	opts := EncodeOptions{
+		AVIF: EncoderOptions{Quality: intPointer(50)},
+	}.WithDefaults(EncodeOptions{
+		AVIF: EncoderOptions{Quality: intPointer(80), Effort: intPointer(4)},
+		GIF:  EncoderOptions{Quality: intPointer(90)},
+	})
+
+	assert.Equal(t, 50, *opts.AVIF.Quality, "The job option wins")
+	assert.Equal(t, 4, *opts.AVIF.Effort, "Missing options come from the defaults")
+	assert.Equal(t, 90, *opts.GIF.Quality, "Missing options come from the defaults")
+	assert.Nil(t, opts.WEBP.Quality, "Options without a default are left unset")
+}
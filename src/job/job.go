@@ -1,17 +1,28 @@
 package job
 
 import (
+	"fmt"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
 )
 
+var ErrBadTrim = fmt.Errorf("bad trim")
+
 type Job struct {
 	ID string `json:"id"`
 
 	AspectRatioXY []int                `json:"aspect_ratio_xy"`
 	Sizes         map[string]ImageSize `json:"sizes"`
 	Settings      uint64               `json:"settings"`
+	Framing       Framing              `json:"framing"`
+	Trim          Trim                 `json:"trim"`
+	EncodeOptions EncodeOptions        `json:"encode_options"`
+	Limits        Limits               `json:"limits"`
+	// Priority above 0 marks an interactive job, it may use the reserved workers and is admitted ahead of lower priorities.
+	Priority int `json:"priority,omitempty"`
+	// AllowPartial lets the job succeed with the outputs which could be made when some of them fail.
+	AllowPartial bool `json:"allow_partial,omitempty"`
 
 	RawProvider           RawProvider         `json:"raw_provider"`
 	RawProviderDetails    jsoniter.RawMessage `json:"raw_provider_details"`
@@ -28,9 +39,17 @@ const (
 	EnableOutputStaticPNG
 	EnableOutputAnimated
 	EnableOutputAnimatedThumbanils
+	// AllSettings is what a job without settings gets, it is frozen so new formats do not change the outputs of existing clients.
 	AllSettings uint64 = (1 << iota) - 1
 )
 
+// The formats below are opt in and are left out of AllSettings.
+const (
+	EnableOutputAnimatedJXL uint64 = EnableOutputAnimatedThumbanils << (iota + 1)
+	EnableOutputStaticJXL
+	EnableOutputAnimatedPNG
+)
+
 type File struct {
 	Name        string        `json:"name"`
 	Size        int           `json:"size"`
@@ -39,6 +58,73 @@ type File struct {
 	TimeTaken   time.Duration `json:"time_taken"`
 	Width       int           `json:"width"`
 	Height      int           `json:"height"`
+	// Quality is the quality the file was encoded at when it was set or had to be lowered to fit the max bytes.
+	Quality *int `json:"quality,omitempty"`
+	// Lossy and Colours are how much a gif was reduced to fit the max bytes.
+	Lossy   int `json:"lossy,omitempty"`
+	Colours int `json:"colours,omitempty"`
+}
+
+// Failure is an output which could not be made in a job which allows partial results.
+type Failure struct {
+	Format string `json:"format"`
+	Size   string `json:"size"`
+	Name   string `json:"name"`
+	Error  string `json:"error"`
+}
+
+// Framing decides how frames are fit into each of the sizes.
+type Framing struct {
+	Fit     Fit     `json:"fit"`
+	Gravity Gravity `json:"gravity"`
+	// PadColor is #RRGGBB or #RRGGBBAA, it defaults to transparent.
+	PadColor string `json:"pad_color"`
+}
+
+type Fit string
+
+const (
+	// FitDefault pads the frames to the aspect ratio of the job and then resizes them to fit within each size.
+	FitDefault Fit = ""
+	// FitContain resizes the frames to fit within each size and pads them to exactly that size.
+	FitContain Fit = "contain"
+	// FitCover resizes the frames to fill each size and crops whatever overflows.
+	FitCover Fit = "cover"
+	// FitStretch resizes the frames to exactly each size ignoring their aspect ratio.
+	FitStretch Fit = "stretch"
+	// FitNone resizes the frames to fit within each size without any padding.
+	FitNone Fit = "none"
+)
+
+type Gravity string
+
+const (
+	GravityCenter    Gravity = "center"
+	GravityNorth     Gravity = "north"
+	GravitySouth     Gravity = "south"
+	GravityEast      Gravity = "east"
+	GravityWest      Gravity = "west"
+	GravityNorthEast Gravity = "northeast"
+	GravityNorthWest Gravity = "northwest"
+	GravitySouthEast Gravity = "southeast"
+	GravitySouthWest Gravity = "southwest"
+)
+
+// Trim crops away the transparent border shared by every frame before they are resized.
+type Trim struct {
+	Enabled bool `json:"enabled"`
+	// AlphaThreshold is the alpha a pixel must be above to count as content.
+	AlphaThreshold uint8 `json:"alpha_threshold"`
+	// Margin is the number of pixels kept around the content.
+	Margin int `json:"margin"`
+}
+
+func (t Trim) Validate() error {
+	if t.Margin < 0 {
+		return fmt.Errorf("%w: margin must not be negative", ErrBadTrim)
+	}
+
+	return nil
 }
 
 type ImageSize struct {
@@ -61,6 +147,13 @@ type RawProviderDetailsLocal struct {
 	Path string `json:"path"`
 }
 
+type RawProviderDetailsHttp struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Size    int64             `json:"size"`
+	Sha256  string            `json:"sha256"`
+}
+
 type ResultConsumerDetailsAws struct {
 	Bucket    string `json:"bucket"`
 	KeyFolder string `json:"key_folder"`
@@ -75,6 +168,7 @@ type RawProvider string
 const (
 	AwsProvider   RawProvider = "aws"
 	LocalProvider RawProvider = "local"
+	HttpProvider  RawProvider = "http"
 )
 
 type ResultConsumer string
@@ -0,0 +1,12 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TrimValidate(t *testing.T) {
+	assert.ErrorIs(t, Trim{Enabled: true, Margin: 2}.Validate(), nil, "A positive margin is fine")
+	assert.ErrorIs(t, Trim{Enabled: true, Margin: -1}.Validate(), ErrBadTrim, "A negative margin grows past the frame")
+}
@@ -0,0 +1,107 @@
+package job
+
+import "fmt"
+
+var ErrInputTooLarge = fmt.Errorf("input too large")
+
+// LimitError is returned when the input goes over one of the limits, it matches ErrInputTooLarge.
+type LimitError struct {
+	Limit string
+	Value int64
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s is %d which is more than %d", ErrInputTooLarge.Error(), e.Limit, e.Value, e.Max)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrInputTooLarge
+}
+
+// Limits bound how large an input may be, 0 means no limit.
+type Limits struct {
+	MaxBytes  int64 `json:"max_bytes,omitempty" mapstructure:"max_bytes,omitempty"`
+	MaxWidth  int64 `json:"max_width,omitempty" mapstructure:"max_width,omitempty"`
+	MaxHeight int64 `json:"max_height,omitempty" mapstructure:"max_height,omitempty"`
+	MaxFrames int64 `json:"max_frames,omitempty" mapstructure:"max_frames,omitempty"`
+	// MaxDuration is the longest the animation may play for in milliseconds.
+	MaxDuration int64 `json:"max_duration,omitempty" mapstructure:"max_duration,omitempty"`
+	// MaxPixels is the most pixels the frames may decode to, width * height * frames.
+	MaxPixels int64 `json:"max_pixels,omitempty" mapstructure:"max_pixels,omitempty"`
+}
+
+var DefaultLimits = Limits{
+	MaxBytes:    50 * 1024 * 1024,
+	MaxWidth:    8192,
+	MaxHeight:   8192,
+	MaxFrames:   2000,
+	MaxDuration: 5 * 60 * 1000,
+	MaxPixels:   1 << 30,
+}
+
+func withDefault(v int64, def int64) int64 {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+func within(v int64, max int64) int64 {
+	if v <= 0 || (max > 0 && v > max) {
+		return max
+	}
+	return v
+}
+
+// WithDefaults fills in every limit which was not set from def.
+func (l Limits) WithDefaults(def Limits) Limits {
+	return Limits{
+		MaxBytes:    withDefault(l.MaxBytes, def.MaxBytes),
+		MaxWidth:    withDefault(l.MaxWidth, def.MaxWidth),
+		MaxHeight:   withDefault(l.MaxHeight, def.MaxHeight),
+		MaxFrames:   withDefault(l.MaxFrames, def.MaxFrames),
+		MaxDuration: withDefault(l.MaxDuration, def.MaxDuration),
+		MaxPixels:   withDefault(l.MaxPixels, def.MaxPixels),
+	}
+}
+
+// Within lowers every limit to max, a job can only tighten the limits of the config.
+func (l Limits) Within(max Limits) Limits {
+	return Limits{
+		MaxBytes:    within(l.MaxBytes, max.MaxBytes),
+		MaxWidth:    within(l.MaxWidth, max.MaxWidth),
+		MaxHeight:   within(l.MaxHeight, max.MaxHeight),
+		MaxFrames:   within(l.MaxFrames, max.MaxFrames),
+		MaxDuration: within(l.MaxDuration, max.MaxDuration),
+		MaxPixels:   within(l.MaxPixels, max.MaxPixels),
+	}
+}
+
+func check(limit string, value int64, max int64) error {
+	if max > 0 && value > max {
+		return &LimitError{Limit: limit, Value: value, Max: max}
+	}
+	return nil
+}
+
+func (l Limits) CheckBytes(size int64) error {
+	return check("bytes", size, l.MaxBytes)
+}
+
+// Check compares what was probed from the input against the limits, duration is in milliseconds.
+func (l Limits) Check(width int, height int, frames int, duration int) error {
+	if err := check("width", int64(width), l.MaxWidth); err != nil {
+		return err
+	}
+	if err := check("height", int64(height), l.MaxHeight); err != nil {
+		return err
+	}
+	if err := check("frames", int64(frames), l.MaxFrames); err != nil {
+		return err
+	}
+	if err := check("duration", int64(duration), l.MaxDuration); err != nil {
+		return err
+	}
+	return check("pixels", int64(width)*int64(height)*int64(frames), l.MaxPixels)
+}
@@ -0,0 +1,46 @@
+package job
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Limits(t *testing.T) {
+	server := Limits{MaxWidth: 1000}.WithDefaults(DefaultLimits)
+	assert.Equal(t, int64(1000), server.MaxWidth, "The config wins over the defaults")
+	assert.Equal(t, DefaultLimits.MaxFrames, server.MaxFrames, "Missing limits come from the defaults")
+
+	limits := Limits{MaxWidth: 2000, MaxFrames: 10}.Within(server)
+	assert.Equal(t, int64(1000), limits.MaxWidth, "A job cannot raise a limit")
+	assert.Equal(t, int64(10), limits.MaxFrames, "A job can lower a limit")
+	assert.Equal(t, server.MaxHeight, limits.MaxHeight, "Missing limits come from the config")
+
+	assert.ErrorIs(t, limits.Check(1000, 1000, 10, 1000), nil, "Inputs on the limits are fine")
+
+	tests := []struct {
+		name   string
+		err    error
+		limit  string
+		value  int64
+		maxVal int64
+	}{
+		{"width", limits.Check(1001, 10, 1, 0), "width", 1001, 1000},
+		{"frames", limits.Check(10, 10, 11, 0), "frames", 11, 10},
+		{"duration", limits.Check(10, 10, 1, int(limits.MaxDuration)+1), "duration", limits.MaxDuration + 1, limits.MaxDuration},
+		{"pixels", Limits{MaxPixels: 99}.Check(10, 10, 1, 0), "pixels", 100, 99},
+		{"bytes", Limits{MaxBytes: 5}.CheckBytes(6), "bytes", 6, 5},
+	}
+
+	for _, test := range tests {
+		limitErr := &LimitError{}
+		if !assert.True(t, errors.As(test.err, &limitErr), test.name) {
+			continue
+		}
+		assert.True(t, errors.Is(test.err, ErrInputTooLarge), test.name)
+		assert.Equal(t, test.limit, limitErr.Limit, test.name)
+		assert.Equal(t, test.value, limitErr.Value, test.name)
+		assert.Equal(t, test.maxVal, limitErr.Max, test.name)
+	}
+}
@@ -0,0 +1,125 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/seventv/ImageProcessor/src/image"
+	"github.com/seventv/ImageProcessor/src/job"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// SchemaVersion is bumped whenever a field of the manifest changes meaning or is removed, adding a field does not bump it.
+const SchemaVersion = 1
+
+// Name is the file the manifest is written to next to the outputs.
+const Name = "manifest.json"
+
+// ProcessorVersion is the version of the processor which made the outputs, it is set from the build on startup.
+var ProcessorVersion = "development"
+
+// Source describes the input of a job as it was probed.
+type Source struct {
+	Type   image.ImageType `json:"type"`
+	Width  int             `json:"width"`
+	Height int             `json:"height"`
+	Frames int             `json:"frames"`
+	// Duration of all the frames together in milliseconds.
+	Duration int `json:"duration"`
+}
+
+// File is an output as described by its bytes and how it was encoded, how long it took is left out as it changes every run.
+type File struct {
+	Name        string `json:"name"`
+	Size        int    `json:"size"`
+	ContentType string `json:"content_type"`
+	Animated    bool   `json:"animated"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Quality     *int   `json:"quality,omitempty"`
+	Lossy       int    `json:"lossy,omitempty"`
+	Colours     int    `json:"colours,omitempty"`
+	Sha256      string `json:"sha256"`
+}
+
+// Manifest lists everything a job produced, it is written next to the outputs so consumers do not have to rely on the result message.
+type Manifest struct {
+	Schema           int           `json:"schema"`
+	JobID            string        `json:"job_id"`
+	ProcessorVersion string        `json:"processor_version"`
+	Source           Source        `json:"source"`
+	Files            []File        `json:"files"`
+	Failures         []job.Failure `json:"failures,omitempty"`
+}
+
+// New builds the manifest from the outputs in dir, it only depends on the files so it can be made again at any time.
+func New(jobID string, source Source, files []job.File, failures []job.Failure, dir string) (*Manifest, error) {
+	m := &Manifest{
+		Schema:           SchemaVersion,
+		JobID:            jobID,
+		ProcessorVersion: ProcessorVersion,
+		Source:           source,
+		Files:            make([]File, len(files)),
+		Failures:         failures,
+	}
+
+	for i, f := range files {
+		sum, err := checksum(path.Join(dir, f.Name))
+		if err != nil {
+			return nil, err
+		}
+
+		m.Files[i] = File{
+			Name:        f.Name,
+			Size:        f.Size,
+			ContentType: f.ContentType,
+			Animated:    f.Animated,
+			Width:       f.Width,
+			Height:      f.Height,
+			Quality:     f.Quality,
+			Lossy:       f.Lossy,
+			Colours:     f.Colours,
+			Sha256:      sum,
+		}
+	}
+
+	// the outputs are encoded in parallel so they are sorted to keep the manifest the same for the same outputs.
+	sort.Slice(m.Files, func(i, j int) bool {
+		return m.Files[i].Name < m.Files[j].Name
+	})
+
+	return m, nil
+}
+
+// Write saves the manifest into dir and returns the path of the file.
+func (m *Manifest) Write(dir string) (string, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	file := path.Join(dir, Name)
+
+	return file, os.WriteFile(file, data, 0600)
+}
+
+func checksum(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
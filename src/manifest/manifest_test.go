@@ -0,0 +1,42 @@
+package manifest
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/seventv/ImageProcessor/src/image"
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_New(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.WriteFile(path.Join(dir, "2x.webp"), []byte("webp"), 0600)
+	_ = os.WriteFile(path.Join(dir, "1x.avif"), []byte("avif"), 0600)
+
+	source := Source{Type: image.GIF, Width: 96, Height: 32, Frames: 2, Duration: 200}
+	files := []job.File{{Name: "2x.webp"}, {Name: "1x.avif"}}
+
+	m, err := New("a", source, files, nil, dir)
+	assert.ErrorIs(t, err, nil, "no error when building the manifest")
+	assert.Equal(t, SchemaVersion, m.Schema, "The manifest has the schema version")
+	assert.Equal(t, "1x.avif", m.Files[0].Name, "The files are sorted")
+	assert.Equal(t, "2f47c57aae31f07fca22e0d766b60069731cfa8a897772810161d5e7a20ec7c0", m.Files[0].Sha256, "The checksum is of the file")
+
+	file, err := m.Write(dir)
+	assert.ErrorIs(t, err, nil, "no error when writing the manifest")
+
+	first, _ := os.ReadFile(file)
+
+	// the same outputs from another run, or from the cache, took a different time to make.
+	m, _ = New("a", source, []job.File{{Name: "1x.avif", TimeTaken: time.Second}, {Name: "2x.webp", TimeTaken: time.Minute}}, nil, dir)
+	_, _ = m.Write(dir)
+
+	second, _ := os.ReadFile(file)
+	assert.Equal(t, string(first), string(second), "The manifest is the same when made again")
+
+	_, err = New("a", source, []job.File{{Name: "3x.webp"}}, nil, dir)
+	assert.Error(t, err, "A missing output fails the manifest")
+}
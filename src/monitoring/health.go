@@ -0,0 +1,113 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/seventv/ImageProcessor/src/cache"
+	"github.com/seventv/ImageProcessor/src/global"
+)
+
+const DefaultMinFreeSpace int64 = 512 * 1024 * 1024
+
+var (
+	ErrDraining     = fmt.Errorf("shutting down")
+	ErrLowFreeSpace = fmt.Errorf("working dir is low on space")
+)
+
+type check struct {
+	name string
+	fn   func(ctx global.Context) error
+}
+
+var readyChecks = []check{
+	{"draining", checkDraining},
+	{"rmq", checkRmq},
+	{"s3", checkS3},
+	{"working_dir", checkWorkingDir},
+}
+
+// ready runs every readiness check and returns the failures by check name.
+func ready(ctx global.Context) map[string]string {
+	failures := map[string]string{}
+	for _, c := range readyChecks {
+		if err := c.fn(ctx); err != nil {
+			failures[c.name] = err.Error()
+		}
+	}
+
+	return failures
+}
+
+// checkDraining fails once shutdown has begun, the global context is cancelled as soon as a signal is received.
+func checkDraining(ctx global.Context) error {
+	if ctx.Err() != nil {
+		return ErrDraining
+	}
+
+	return nil
+}
+
+func checkRmq(ctx global.Context) error {
+	if ctx.Instances().Rmq == nil {
+		return nil
+	}
+
+	return ctx.Instances().Rmq.Healthy()
+}
+
+// checkS3 heads health.bucket, or the bucket of the s3 cache when it is not set.
+func checkS3(ctx global.Context) error {
+	bucket := ctx.Config().Health.Bucket
+	if bucket == "" && ctx.Config().Cache.Backend == string(cache.S3Backend) {
+		bucket = ctx.Config().Cache.Bucket
+	}
+
+	if ctx.Instances().AwsS3 == nil || bucket == "" {
+		return nil
+	}
+
+	lCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return ctx.Instances().AwsS3.HeadBucket(lCtx, bucket)
+}
+
+func checkWorkingDir(ctx global.Context) error {
+	dir := ctx.Config().WorkingDir
+	if dir == "" {
+		dir = "."
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	_ = f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return err
+	}
+
+	minFree := ctx.Config().Health.MinFreeSpace
+	if minFree <= 0 {
+		minFree = DefaultMinFreeSpace
+	}
+
+	stat := syscall.Statfs_t{}
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return err
+	}
+
+	if free := int64(stat.Bavail) * int64(stat.Bsize); free < minFree {
+		return fmt.Errorf("%w: %d bytes free", ErrLowFreeSpace, free)
+	}
+
+	return nil
+}
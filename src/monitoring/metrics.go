@@ -0,0 +1,130 @@
+package monitoring
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "image_processor"
+
+var (
+	tasksStarted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_started_total",
+		Help:      "The number of tasks which have been started.",
+	})
+	tasksCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_completed_total",
+		Help:      "The number of tasks which have completed successfully by the detected input type.",
+	}, []string{"type"})
+	tasksFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_failed_total",
+		Help:      "The number of tasks which have failed by the detected input type and error class.",
+	}, []string{"type", "error"})
+	taskDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "task_duration_seconds",
+		Help:      "The time taken to process a task by the detected input type.",
+		Buckets:   prometheus.ExponentialBuckets(0.25, 2, 10),
+	}, []string{"type"})
+	stageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "stage_duration_seconds",
+		Help:      "The time taken by each stage of a task.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"stage"})
+	encoderDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "encoder_duration_seconds",
+		Help:      "The time taken to produce an output file by content type.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"content_type", "animated"})
+	encoderOutput = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "encoder_output_bytes",
+		Help:      "The size of the produced output files by content type.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 2, 14),
+	}, []string{"content_type", "animated"})
+	workers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers",
+		Help:      "The number of task workers by state.",
+	}, []string{"state"})
+	cacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_hits_total",
+		Help:      "The number of tasks served from the cache by the detected input type.",
+	}, []string{"type"})
+	execFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "exec_failures_total",
+		Help:      "The number of times an external tool has failed by binary.",
+	}, []string{"binary"})
+)
+
+func TaskStarted() {
+	tasksStarted.Inc()
+}
+
+func TaskCompleted(imgType string, duration time.Duration) {
+	if imgType == "" {
+		imgType = "unknown"
+	}
+
+	tasksCompleted.WithLabelValues(imgType).Inc()
+	taskDuration.WithLabelValues(imgType).Observe(duration.Seconds())
+}
+
+func TaskFailed(imgType string, errClass string, duration time.Duration) {
+	if imgType == "" {
+		imgType = "unknown"
+	}
+
+	tasksFailed.WithLabelValues(imgType, errClass).Inc()
+	taskDuration.WithLabelValues(imgType).Observe(duration.Seconds())
+}
+
+func StageDuration(stage string, duration time.Duration) {
+	stageDuration.WithLabelValues(stage).Observe(duration.Seconds())
+}
+
+func EncoderOutput(contentType string, animated bool, duration time.Duration, size int) {
+	lbl := "false"
+	if animated {
+		lbl = "true"
+	}
+
+	encoderDuration.WithLabelValues(contentType, lbl).Observe(duration.Seconds())
+	encoderOutput.WithLabelValues(contentType, lbl).Observe(float64(size))
+}
+
+func CacheHit(imgType string) {
+	if imgType == "" {
+		imgType = "unknown"
+	}
+
+	cacheHits.WithLabelValues(imgType).Inc()
+}
+
+func WorkersTotal(n int) {
+	workers.WithLabelValues("idle").Set(float64(n))
+	workers.WithLabelValues("busy").Set(0)
+}
+
+func WorkerBusy() {
+	workers.WithLabelValues("idle").Dec()
+	workers.WithLabelValues("busy").Inc()
+}
+
+func WorkerIdle() {
+	workers.WithLabelValues("busy").Dec()
+	workers.WithLabelValues("idle").Inc()
+}
+
+func ExecFailed(binary string) {
+	execFailures.WithLabelValues(binary).Inc()
+}
@@ -0,0 +1,59 @@
+package monitoring
+
+import (
+	"errors"
+	"net/http"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/seventv/ImageProcessor/src/global"
+	"github.com/sirupsen/logrus"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// New serves the metrics and health endpoints.
+func New(ctx global.Context) {
+	bind := ctx.Config().Monitoring.Bind
+	if bind == "" {
+		bind = "0.0.0.0:9100"
+	}
+
+	mux := http.NewServeMux()
+	// the probes do not depend on metrics being enabled, an orchestrator needs them either way.
+	if ctx.Config().Monitoring.Enabled {
+		mux.Handle("/metrics", promhttp.Handler())
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		failures := ready(ctx)
+
+		status := http.StatusOK
+		if len(failures) != 0 {
+			status = http.StatusServiceUnavailable
+		}
+
+		data, _ := json.Marshal(map[string]interface{}{
+			"ready":    len(failures) == 0,
+			"failures": failures,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write(data)
+	})
+
+	// the server is not shutdown with the global context so readiness can report draining until the process exits.
+	srv := &http.Server{
+		Addr:    bind,
+		Handler: mux,
+	}
+
+	logrus.Info("monitoring listening on ", bind)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logrus.Fatal("failed to serve monitoring: ", err)
+	}
+}
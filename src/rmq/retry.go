@@ -0,0 +1,84 @@
+package rmq
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/seventv/ImageProcessor/src/configure"
+	"github.com/streadway/amqp"
+)
+
+// Headers set on retried and dead lettered jobs.
+const (
+	HeaderAttempts  = "x-attempts"
+	HeaderError     = "x-error"
+	HeaderErrorType = "x-error-type"
+)
+
+const (
+	DefaultMaxAttempts   = 3
+	DefaultRetryDelay    = time.Second * 10
+	DefaultMaxRetryDelay = time.Minute * 10
+)
+
+func MaxAttempts(config *configure.Config) int {
+	if config.Rmq.MaxAttempts <= 0 {
+		return DefaultMaxAttempts
+	}
+
+	return config.Rmq.MaxAttempts
+}
+
+func DeadLetterQueueName(config *configure.Config) string {
+	if config.Rmq.DeadLetterQueueName == "" {
+		return config.Rmq.JobQueueName + ".dead"
+	}
+
+	return config.Rmq.DeadLetterQueueName
+}
+
+// RetryDelay is the backoff before the given retry, the first retry is attempt 1.
+func RetryDelay(config *configure.Config, attempt int) time.Duration {
+	delay := time.Second * time.Duration(config.Rmq.RetryDelay)
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+
+	maxDelay := time.Second * time.Duration(config.Rmq.MaxRetryDelay)
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxRetryDelay
+	}
+
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	return delay
+}
+
+// RetryQueueName is the delay queue holding jobs from the job queue named queue waiting for the given retry.
+// Messages sit in it until its ttl expires and are then dead lettered back onto their job queue.
+// The delay is part of the name so changing the backoff does not clash with queues declared with the old ttl.
+func RetryQueueName(config *configure.Config, queue string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", queue, RetryDelay(config, attempt).Milliseconds())
+}
+
+// Attempts reads how many times a job has already been tried from its headers.
+func Attempts(headers amqp.Table) int {
+	switch v := headers[HeaderAttempts].(type) {
+	case int:
+		return v
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	}
+
+	return 0
+}
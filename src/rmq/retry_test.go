@@ -0,0 +1,27 @@
+package rmq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/seventv/ImageProcessor/src/configure"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RetryDelay(t *testing.T) {
+	config := &configure.Config{}
+	config.Rmq.JobQueueName = "jobs"
+	config.Rmq.RetryDelay = 5
+	config.Rmq.MaxRetryDelay = 30
+
+	assert.Equal(t, time.Second*5, RetryDelay(config, 1), "The first retry waits the base delay")
+	assert.Equal(t, time.Second*10, RetryDelay(config, 2), "The delay doubles")
+	assert.Equal(t, time.Second*20, RetryDelay(config, 3), "The delay doubles")
+	assert.Equal(t, time.Second*30, RetryDelay(config, 4), "The delay is capped")
+	assert.Equal(t, "jobs.retry.10000", RetryQueueName(config, "jobs", 2), "The queue is named after its delay")
+	assert.Equal(t, "jobs.dead", DeadLetterQueueName(config), "The dead letter queue defaults to the job queue name")
+
+	assert.Equal(t, 0, Attempts(nil), "A new job has no attempts")
+	assert.Equal(t, 2, Attempts(amqp.Table{HeaderAttempts: int32(2)}), "The attempts are read from the headers")
+}
@@ -1,99 +1,430 @@
 package rmq
 
 import (
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/seventv/ImageProcessor/src/configure"
 	"github.com/seventv/ImageProcessor/src/global"
 	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
 )
 
+var (
+	ErrConnectionClosed = fmt.Errorf("rmq connection is closed")
+	ErrShutdown         = fmt.Errorf("rmq is shutting down")
+)
+
+const (
+	DefaultReconnectDelay    = time.Second
+	DefaultMaxReconnectDelay = time.Second * 30
+	DefaultPublishTimeout    = time.Second * 30
+)
+
 type RmqInstance struct {
+	config *configure.Config
+
+	mtx   sync.Mutex
 	rmq   *amqp.Connection
 	chRmq *amqp.Channel
+	// ready is closed while connected and replaced with a new channel when the connection drops.
+	ready    chan struct{}
+	shutdown chan struct{}
+	closed   bool
 }
 
+// New connects to rmq and keeps the connection alive, if the broker goes away it reconnects with backoff,
+// redeclares the queues and resumes every subscription.
 func New(ctx global.Context) global.Rmq {
-	rmq, err := amqp.Dial(ctx.Config().Rmq.ServerURL)
-	if err != nil {
-		logrus.Fatal("failed to connect to rmq: ", err)
+	inst := &RmqInstance{
+		config:   ctx.Config(),
+		ready:    make(chan struct{}),
+		shutdown: make(chan struct{}),
 	}
 
-	chRmq, err := rmq.Channel()
-	if err != nil {
+	// the first connection has to succeed, a bad url or queue config should not be retried forever.
+	if err := inst.connect(); err != nil {
 		logrus.Fatal("failed to connect to rmq: ", err)
 	}
 
-	_, err = chRmq.QueueDeclare(
-		ctx.Config().Rmq.JobQueueName, // queue name
-		true,                          // durable
-		false,                         // auto delete
-		false,                         // exclusive
-		false,                         // no wait
-		nil,                           // arguments
-	)
+	return inst
+}
+
+func (r *RmqInstance) connect() error {
+	rmq, err := amqp.Dial(r.config.Rmq.ServerURL)
 	if err != nil {
-		logrus.Fatal("failed to connect to rmq: ", err)
+		return err
 	}
 
-	_, err = chRmq.QueueDeclare(
-		ctx.Config().Rmq.ResultQueueName, // queue name
-		true,                             // durable
-		false,                            // auto delete
-		false,                            // exclusive
-		false,                            // no wait
-		nil,                              // arguments
-	)
+	chRmq, err := rmq.Channel()
 	if err != nil {
-		logrus.Fatal("failed to connect to rmq: ", err)
+		_ = rmq.Close()
+		return err
+	}
+
+	if r.config.Rmq.Prefetch > 0 {
+		// without a prefetch the broker pushes every queued job to the first processor to connect.
+		if err := chRmq.Qos(r.config.Rmq.Prefetch, 0, false); err != nil {
+			_ = rmq.Close()
+			return err
+		}
+	}
+
+	if err := declare(r.config, chRmq); err != nil {
+		_ = rmq.Close()
+		return err
+	}
+
+	connClosed := rmq.NotifyClose(make(chan *amqp.Error, 1))
+	// the channel can be closed by the server without the connection closing.
+	chClosed := chRmq.NotifyClose(make(chan *amqp.Error, 1))
+
+	r.mtx.Lock()
+	if r.closed {
+		r.mtx.Unlock()
+		_ = rmq.Close()
+		return ErrShutdown
+	}
+	r.rmq = rmq
+	r.chRmq = chRmq
+	close(r.ready)
+	r.mtx.Unlock()
+
+	go r.watch(rmq, connClosed, chClosed)
+
+	return nil
+}
+
+// watch waits for the connection or the channel to close and then reconnects until it succeeds or rmq is shut down.
+func (r *RmqInstance) watch(rmq *amqp.Connection, connClosed chan *amqp.Error, chClosed chan *amqp.Error) {
+	var err *amqp.Error
+	select {
+	case err = <-connClosed:
+	case err = <-chClosed:
 	}
 
-	_, err = chRmq.QueueDeclare(
-		ctx.Config().Rmq.UpdateQueueName, // queue name
-		true,                             // durable
-		false,                            // auto delete
-		false,                            // exclusive
-		false,                            // no wait
-		nil,                              // arguments
+	r.mtx.Lock()
+	if r.closed {
+		r.mtx.Unlock()
+		return
+	}
+	r.ready = make(chan struct{})
+	r.mtx.Unlock()
+
+	// a closed channel leaves the connection open, drop it so everything is rebuilt from scratch.
+	_ = rmq.Close()
+
+	logrus.Warn("rmq connection lost: ", err)
+
+	delay := DefaultReconnectDelay
+	for {
+		select {
+		case <-r.shutdown:
+			return
+		case <-time.After(delay):
+		}
+
+		err := r.connect()
+		if err == nil {
+			logrus.Info("rmq reconnected")
+			return
+		}
+		if errors.Is(err, ErrShutdown) {
+			return
+		}
+
+		logrus.Warnf("failed to reconnect to rmq, retrying in %s: %s", delay, err.Error())
+
+		delay *= 2
+		if delay > DefaultMaxReconnectDelay {
+			delay = DefaultMaxReconnectDelay
+		}
+	}
+}
+
+// JobQueueNames are the job queues to consume, the priority queue is only included when it is configured.
+func JobQueueNames(config *configure.Config) []string {
+	if config.Rmq.PriorityQueueName == "" {
+		return []string{config.Rmq.JobQueueName}
+	}
+
+	return []string{config.Rmq.JobQueueName, config.Rmq.PriorityQueueName}
+}
+
+// ControlExchangeName is the fanout exchange every processor listens to for control messages, such as cancelling a job.
+func ControlExchangeName(config *configure.Config) string {
+	if config.Rmq.ControlExchangeName == "" {
+		return config.Rmq.JobQueueName + ".control"
+	}
+
+	return config.Rmq.ControlExchangeName
+}
+
+func declare(config *configure.Config, chRmq *amqp.Channel) error {
+	queues := append(JobQueueNames(config),
+		config.Rmq.ResultQueueName,
+		config.Rmq.UpdateQueueName,
+		DeadLetterQueueName(config),
 	)
-	if err != nil {
-		logrus.Fatal("failed to connect to rmq: ", err)
+
+	for _, queue := range queues {
+		_, err := chRmq.QueueDeclare(
+			queue, // queue name
+			true,  // durable
+			false, // auto delete
+			false, // exclusive
+			false, // no wait
+			nil,   // arguments
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := chRmq.ExchangeDeclare(
+		ControlExchangeName(config), // exchange name
+		amqp.ExchangeFanout,         // kind
+		true,                        // durable
+		false,                       // auto delete
+		false,                       // internal
+		false,                       // no wait
+		nil,                         // arguments
+	); err != nil {
+		return err
 	}
 
-	return &RmqInstance{
-		rmq:   rmq,
-		chRmq: chRmq,
+	// every retry has its own delay queue, a single queue with per message ttls would hold short delays behind long ones.
+	for _, jobQueue := range JobQueueNames(config) {
+		for attempt := 1; attempt < MaxAttempts(config); attempt++ {
+			_, err := chRmq.QueueDeclare(
+				RetryQueueName(config, jobQueue, attempt), // queue name
+				true,  // durable
+				false, // auto delete
+				false, // exclusive
+				false, // no wait
+				amqp.Table{
+					"x-message-ttl":             RetryDelay(config, attempt).Milliseconds(),
+					"x-dead-letter-exchange":    "",
+					"x-dead-letter-routing-key": jobQueue,
+				}, // arguments
+			)
+			if err != nil {
+				return err
+			}
+		}
 	}
+
+	return nil
 }
 
+// channel waits until rmq is connected, or the timeout passes, and returns the current channel.
+func (r *RmqInstance) channel(timeout <-chan time.Time) (*amqp.Channel, error) {
+	r.mtx.Lock()
+	ready := r.ready
+	r.mtx.Unlock()
+
+	select {
+	case <-ready:
+	case <-r.shutdown:
+		return nil, ErrShutdown
+	case <-timeout:
+		return nil, ErrConnectionClosed
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return r.chRmq, nil
+}
+
+// Subscribe consumes queue for as long as rmq is running, the returned channel survives reconnects and is only closed on Shutdown.
+// Deliveries received before a reconnect can no longer be acked, the broker redelivers them instead, use Current to tell them apart.
 func (r *RmqInstance) Subscribe(queue string) (<-chan amqp.Delivery, error) {
-	return r.chRmq.Consume(
-		queue, // queue name
-		"",    // consumer
-		false, // auto-ack
-		false, // exclusive
-		false, // no local
-		false, // no wait
-		nil,   // arguments
-	)
+	return r.subscribe(queue, func(chRmq *amqp.Channel) (<-chan amqp.Delivery, error) {
+		return chRmq.Consume(
+			queue, // queue name
+			"",    // consumer
+			false, // auto-ack
+			false, // exclusive
+			false, // no local
+			false, // no wait
+			nil,   // arguments
+		)
+	})
+}
+
+// SubscribeFanout receives every message published to the fanout exchange while rmq is running.
+// Each subscription binds a queue of its own which goes away with the connection, so messages sent during an outage are lost.
+func (r *RmqInstance) SubscribeFanout(exchange string) (<-chan amqp.Delivery, error) {
+	return r.subscribe(exchange, func(chRmq *amqp.Channel) (<-chan amqp.Delivery, error) {
+		q, err := chRmq.QueueDeclare(
+			"",    // queue name
+			false, // durable
+			true,  // auto delete
+			true,  // exclusive
+			false, // no wait
+			nil,   // arguments
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := chRmq.QueueBind(
+			q.Name,   // queue name
+			"",       // routing key
+			exchange, // exchange
+			false,    // no wait
+			nil,      // arguments
+		); err != nil {
+			return nil, err
+		}
+
+		return chRmq.Consume(
+			q.Name, // queue name
+			"",     // consumer
+			true,   // auto-ack
+			true,   // exclusive
+			false,  // no local
+			false,  // no wait
+			nil,    // arguments
+		)
+	})
+}
+
+// subscribe calls consume again after every reconnect and forwards the deliveries to a single channel.
+func (r *RmqInstance) subscribe(name string, consume func(chRmq *amqp.Channel) (<-chan amqp.Delivery, error)) (<-chan amqp.Delivery, error) {
+	chRmq, err := r.channel(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := consume(chRmq)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan amqp.Delivery)
+	go func() {
+		defer close(out)
+
+		for {
+			for msg := range msgs {
+				select {
+				case out <- msg:
+				case <-r.shutdown:
+					return
+				}
+			}
+
+			for {
+				chRmq, err := r.channel(nil)
+				if err != nil {
+					return
+				}
+
+				msgs, err = consume(chRmq)
+				if err == nil {
+					logrus.Info("resumed consuming ", name)
+					break
+				}
+
+				logrus.Warnf("failed to resume consuming %s: %s", name, err.Error())
+
+				select {
+				case <-r.shutdown:
+					return
+				case <-time.After(DefaultReconnectDelay):
+				}
+			}
+		}
+	}()
+
+	return out, nil
 }
 
 func (r *RmqInstance) Publish(queue string, contentType string, deliveryMode uint8, msg []byte) error {
-	return r.chRmq.Publish(
-		"",    // exchange
-		queue, // queue name
-		false, // mandatory
-		false, // immediate
-		amqp.Publishing{
-			ContentType:  contentType,
-			DeliveryMode: deliveryMode,
-			Timestamp:    time.Now(),
-			Body:         msg,
-			Priority:     0,
-		}, // message to publish
-	)
+	return r.PublishMessage(queue, amqp.Publishing{
+		ContentType:  contentType,
+		DeliveryMode: deliveryMode,
+		Timestamp:    time.Now(),
+		Body:         msg,
+		Priority:     0,
+	})
+}
+
+// PublishMessage holds on to the message while rmq is reconnecting, it only gives up once the publish timeout has passed.
+func (r *RmqInstance) PublishMessage(queue string, msg amqp.Publishing) error {
+	timeout := time.After(DefaultPublishTimeout)
+
+	for {
+		chRmq, err := r.channel(timeout)
+		if err != nil {
+			return err
+		}
+
+		err = chRmq.Publish(
+			"",    // exchange
+			queue, // queue name
+			false, // mandatory
+			false, // immediate
+			msg,   // message to publish
+		)
+		if !errors.Is(err, amqp.ErrClosed) {
+			return err
+		}
+
+		// the connection dropped under us, wait for the reconnect and try again.
+		select {
+		case <-timeout:
+			return ErrConnectionClosed
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+}
+
+func (r *RmqInstance) Current(msg amqp.Delivery) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	select {
+	case <-r.ready:
+	default:
+		return false
+	}
+
+	return msg.Acknowledger == r.chRmq
+}
+
+func (r *RmqInstance) Healthy() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	select {
+	case <-r.ready:
+	default:
+		return ErrConnectionClosed
+	}
+
+	if r.rmq.IsClosed() {
+		return ErrConnectionClosed
+	}
+
+	return nil
 }
 
 func (r *RmqInstance) Shutdown() {
-	_ = r.rmq.Close()
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	r.closed = true
+	close(r.shutdown)
+	if r.rmq != nil {
+		_ = r.rmq.Close()
+	}
 }
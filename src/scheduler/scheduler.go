@@ -0,0 +1,205 @@
+package scheduler
+
+import (
+	"context"
+	"math/bits"
+	"runtime"
+	"sync"
+
+	"github.com/seventv/ImageProcessor/src/configure"
+	"github.com/seventv/ImageProcessor/src/job"
+)
+
+const (
+	// DefaultCPUBudgetPerCore is how many pixels, summed over every frame and output format, a single core is expected to encode at once.
+	DefaultCPUBudgetPerCore int64 = 64 * 1024 * 1024
+	DefaultMemoryBudget     int64 = 4 * 1024 * 1024 * 1024
+	// bytesPerPixel of a decoded rgba frame.
+	bytesPerPixel = 4
+)
+
+// outputSettings are the settings which each add an encoder run to a job.
+const outputSettings = job.EnableOutputAnimatedGIF |
+	job.EnableOutputAnimatedWEBP |
+	job.EnableOutputAnimatedAVIF |
+	job.EnableOutputStaticWEBP |
+	job.EnableOutputStaticAVIF |
+	job.EnableOutputStaticPNG |
+	job.EnableOutputAnimatedJXL |
+	job.EnableOutputStaticJXL |
+	job.EnableOutputAnimatedPNG
+
+type Cost struct {
+	CPU    int64
+	Memory int64
+}
+
+// Estimate guesses the cost of a job from its probed input, every output format encodes every frame at roughly the input size.
+func Estimate(width int, height int, frames int, settings uint64) Cost {
+	if frames < 1 {
+		frames = 1
+	}
+
+	formats := bits.OnesCount64(settings & outputSettings)
+	if formats < 1 {
+		formats = 1
+	}
+
+	pixels := int64(width) * int64(height) * int64(frames)
+
+	return Cost{
+		CPU:    pixels * int64(formats),
+		Memory: pixels * bytesPerPixel,
+	}
+}
+
+type waiter struct {
+	cost     Cost
+	priority int
+	ready    chan struct{}
+}
+
+// Scheduler admits jobs against a shared cpu and memory budget.
+// Jobs are admitted by priority and then in the order they ask so a large job is never starved by a stream of small ones
+// of the same priority, and a job larger than the whole budget is admitted once nothing else is running.
+type Scheduler struct {
+	cores  int
+	budget Cost
+
+	mtx     sync.Mutex
+	used    Cost
+	waiters []*waiter
+}
+
+func New(config *configure.Config) *Scheduler {
+	cores := runtime.GOMAXPROCS(0)
+
+	s := &Scheduler{
+		cores: cores,
+		budget: Cost{
+			CPU:    config.Scheduler.CPUBudget,
+			Memory: config.Scheduler.MemoryBudget,
+		},
+	}
+
+	if s.budget.CPU <= 0 {
+		s.budget.CPU = DefaultCPUBudgetPerCore * int64(cores)
+	}
+	if s.budget.Memory <= 0 {
+		s.budget.Memory = DefaultMemoryBudget
+	}
+
+	return s
+}
+
+func (s *Scheduler) clamp(cost Cost) Cost {
+	if cost.CPU > s.budget.CPU {
+		cost.CPU = s.budget.CPU
+	}
+	if cost.Memory > s.budget.Memory {
+		cost.Memory = s.budget.Memory
+	}
+
+	return cost
+}
+
+func (s *Scheduler) fits(cost Cost) bool {
+	return s.used.CPU+cost.CPU <= s.budget.CPU && s.used.Memory+cost.Memory <= s.budget.Memory
+}
+
+// Threads is the share of the cores a job of this cost gets, at least one and at most all of them.
+func (s *Scheduler) Threads(cost Cost) int {
+	cost = s.clamp(cost)
+
+	threads := int((cost.CPU*int64(s.cores) + s.budget.CPU - 1) / s.budget.CPU)
+	if threads < 1 {
+		threads = 1
+	}
+
+	return threads
+}
+
+// Acquire blocks until the job fits in the budget, the returned release has to be called once the work is done.
+// A job only waits behind jobs of the same or a higher priority.
+func (s *Scheduler) Acquire(ctx context.Context, cost Cost, priority int) (func(), error) {
+	cost = s.clamp(cost)
+
+	s.mtx.Lock()
+	// the waiters are sorted by priority, so this is where the job queues up.
+	pos := len(s.waiters)
+	for i, v := range s.waiters {
+		if v.priority < priority {
+			pos = i
+			break
+		}
+	}
+
+	if pos == 0 && s.fits(cost) {
+		s.used.CPU += cost.CPU
+		s.used.Memory += cost.Memory
+		s.mtx.Unlock()
+
+		return s.releaser(cost), nil
+	}
+
+	w := &waiter{
+		cost:     cost,
+		priority: priority,
+		ready:    make(chan struct{}),
+	}
+	s.waiters = append(s.waiters[:pos], append([]*waiter{w}, s.waiters[pos:]...)...)
+	s.mtx.Unlock()
+
+	select {
+	case <-w.ready:
+		return s.releaser(cost), nil
+	case <-ctx.Done():
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	select {
+	case <-w.ready:
+		// admitted while giving up, hand the budget straight back.
+		s.used.CPU -= cost.CPU
+		s.used.Memory -= cost.Memory
+	default:
+		for i, v := range s.waiters {
+			if v == w {
+				s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+	s.admit()
+
+	return nil, ctx.Err()
+}
+
+func (s *Scheduler) releaser(cost Cost) func() {
+	once := sync.Once{}
+
+	return func() {
+		once.Do(func() {
+			s.mtx.Lock()
+			defer s.mtx.Unlock()
+
+			s.used.CPU -= cost.CPU
+			s.used.Memory -= cost.Memory
+			s.admit()
+		})
+	}
+}
+
+// admit wakes the waiters at the front of the queue for as long as they fit, it must be called with the lock held.
+func (s *Scheduler) admit() {
+	for len(s.waiters) != 0 && s.fits(s.waiters[0].cost) {
+		w := s.waiters[0]
+		s.waiters = s.waiters[1:]
+
+		s.used.CPU += w.cost.CPU
+		s.used.Memory += w.cost.Memory
+		close(w.ready)
+	}
+}
@@ -0,0 +1,123 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/seventv/ImageProcessor/src/configure"
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/stretchr/testify/assert"
+)
+
+func testScheduler(cores int) *Scheduler {
+	config := &configure.Config{}
+	config.Scheduler.CPUBudget = 100
+	config.Scheduler.MemoryBudget = 1000
+
+	s := New(config)
+	s.cores = cores
+
+	return s
+}
+
+func Test_Estimate(t *testing.T) {
+	cost := Estimate(10, 10, 5, job.EnableOutputAnimatedWEBP|job.EnableOutputAnimatedAVIF|job.EnableOutputAnimated)
+	assert.Equal(t, int64(10*10*5*2), cost.CPU, "Every output format adds to the cpu cost")
+	assert.Equal(t, int64(10*10*5*4), cost.Memory, "Memory is the size of the decoded frames")
+
+	cost = Estimate(10, 10, 0, 0)
+	assert.Equal(t, int64(100), cost.CPU, "A job always costs at least one frame and format")
+}
+
+func Test_Threads(t *testing.T) {
+	s := testScheduler(8)
+
+	assert.Equal(t, 1, s.Threads(Cost{CPU: 1}), "A tiny job gets a single thread")
+	assert.Equal(t, 4, s.Threads(Cost{CPU: 50}), "Half the budget gets half the cores")
+	assert.Equal(t, 8, s.Threads(Cost{CPU: 1000}), "A job over the budget gets every core")
+}
+
+func Test_Acquire(t *testing.T) {
+	s := testScheduler(8)
+	ctx := context.Background()
+
+	releaseLarge, err := s.Acquire(ctx, Cost{CPU: 80}, 0)
+	assert.ErrorIs(t, err, nil, "The first job is admitted")
+
+	admitted := make(chan string, 2)
+	go func() {
+		release, _ := s.Acquire(ctx, Cost{CPU: 1000}, 0)
+		admitted <- "huge"
+		time.Sleep(time.Millisecond * 10)
+		release()
+	}()
+
+	// wait for the huge job to queue up before the small one.
+	for {
+		s.mtx.Lock()
+		n := len(s.waiters)
+		s.mtx.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	go func() {
+		release, _ := s.Acquire(ctx, Cost{CPU: 10}, 0)
+		admitted <- "small"
+		release()
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+	assert.Equal(t, 0, len(admitted), "Nothing is admitted while the budget is used")
+
+	releaseLarge()
+	releaseLarge()
+
+	assert.Equal(t, "huge", <-admitted, "Waiting jobs are admitted in order")
+	assert.Equal(t, "small", <-admitted, "Waiting jobs are admitted in order")
+
+	releaseLarge, _ = s.Acquire(ctx, Cost{CPU: 100}, 0)
+	cancelCtx, cancel := context.WithTimeout(ctx, time.Millisecond*10)
+	defer cancel()
+
+	_, err = s.Acquire(cancelCtx, Cost{CPU: 10}, 0)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "Giving up on a job returns the context error")
+	releaseLarge()
+
+	assert.Equal(t, Cost{}, s.used, "Every job handed its budget back")
+	assert.Equal(t, 0, len(s.waiters), "No job is left waiting")
+}
+
+func Test_AcquirePriority(t *testing.T) {
+	s := testScheduler(8)
+	ctx := context.Background()
+
+	releaseFirst, _ := s.Acquire(ctx, Cost{CPU: 80}, 0)
+
+	admitted := make(chan string, 1)
+	go func() {
+		release, _ := s.Acquire(ctx, Cost{CPU: 50}, 0)
+		admitted <- "bulk"
+		release()
+	}()
+
+	for {
+		s.mtx.Lock()
+		n := len(s.waiters)
+		s.mtx.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	release, err := s.Acquire(ctx, Cost{CPU: 10}, 1)
+	assert.ErrorIs(t, err, nil, "A priority job that fits skips the waiting bulk job")
+	release()
+
+	releaseFirst()
+	assert.Equal(t, "bulk", <-admitted, "The bulk job is admitted once there is room")
+}
@@ -0,0 +1,102 @@
+package task
+
+import (
+	"sync"
+	"time"
+
+	"github.com/seventv/ImageProcessor/src/global"
+	"github.com/seventv/ImageProcessor/src/rmq"
+	"github.com/sirupsen/logrus"
+)
+
+// ControlMessage is published to the control exchange, every processor receives it and acts on the jobs it is running.
+type ControlMessage struct {
+	Cancel string `json:"cancel,omitempty"`
+}
+
+// runningTasks tracks the tasks of this processor by job id so they can be cancelled remotely.
+// A cancel for a job which has not started yet is remembered so the job is stopped as soon as it starts.
+type runningTasks struct {
+	mtx       sync.Mutex
+	tasks     map[string]*Task
+	cancelled map[string]time.Time
+	retention time.Duration
+}
+
+func newRunningTasks(retention time.Duration) *runningTasks {
+	return &runningTasks{
+		tasks:     map[string]*Task{},
+		cancelled: map[string]time.Time{},
+		retention: retention,
+	}
+}
+
+// add tracks the task until remove is called, it returns false when the job was cancelled before it started.
+func (r *runningTasks) add(task *Task) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	id := task.Job().ID
+	if _, ok := r.cancelled[id]; ok {
+		delete(r.cancelled, id)
+		return false
+	}
+
+	r.tasks[id] = task
+
+	return true
+}
+
+func (r *runningTasks) remove(task *Task) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	id := task.Job().ID
+	if r.tasks[id] == task {
+		delete(r.tasks, id)
+	}
+}
+
+// cancel stops the task running the job, or remembers the job in case it has not reached this processor yet.
+func (r *runningTasks) cancel(id string) {
+	r.mtx.Lock()
+
+	now := time.Now()
+	for k, v := range r.cancelled {
+		if now.Sub(v) > r.retention {
+			delete(r.cancelled, k)
+		}
+	}
+
+	task, ok := r.tasks[id]
+	if !ok {
+		r.cancelled[id] = now
+	}
+	r.mtx.Unlock()
+
+	if ok {
+		logrus.Info("cancelling task: ", id)
+		// stopping emits a stopped event to whoever is consuming the events of the task.
+		task.Stop()
+	}
+}
+
+// listenControl acts on control messages until rmq shuts down.
+func listenControl(ctx global.Context, running *runningTasks) {
+	msgCh, err := ctx.Instances().Rmq.SubscribeFanout(rmq.ControlExchangeName(ctx.Config()))
+	if err != nil {
+		logrus.Fatal("failed to listen to control messages: ", err)
+	}
+
+	for msg := range msgCh {
+		ctrl := ControlMessage{}
+		if err := json.Unmarshal(msg.Body, &ctrl); err != nil {
+			logrus.Warn("bad control message: ", err)
+			continue
+		}
+
+		if ctrl.Cancel != "" {
+			running.cancel(ctrl.Cancel)
+		}
+	}
+}
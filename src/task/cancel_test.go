@@ -0,0 +1,29 @@
+package task
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_runningTasks(t *testing.T) {
+	running := newRunningTasks(time.Minute)
+
+	task := New(context.Background(), job.Job{ID: "running"})
+	assert.True(t, running.add(task), "A job which was not cancelled is tracked")
+
+	running.cancel("running")
+	assert.True(t, task.Stopped(), "Cancelling a running job stops its task")
+	assert.Equal(t, Stopped, (<-task.Events()).Type, "Stopping the task emits a stopped event")
+
+	running.remove(task)
+	assert.Equal(t, 0, len(running.tasks), "The task is no longer tracked")
+
+	running.cancel("late")
+	late := New(context.Background(), job.Job{ID: "late"})
+	assert.False(t, running.add(late), "A job cancelled before it started is not run")
+	assert.Equal(t, 0, len(running.cancelled), "The cancel is only used once")
+}
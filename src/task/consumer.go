@@ -0,0 +1,99 @@
+package task
+
+import (
+	"context"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/seventv/ImageProcessor/src/aws"
+	"github.com/seventv/ImageProcessor/src/global"
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/seventv/ImageProcessor/src/utils"
+)
+
+// deliverer hands a finished output file to the result consumer of the job.
+// The content type is passed in rather than guessed from the extension as an apng shares its extension with a png.
+type deliverer func(ctx context.Context, file string, contentType string) error
+
+// newDeliverer reads the result consumer details up front so a bad job fails before any work is done.
+func newDeliverer(ctx global.Context, j job.Job) (deliverer, error) {
+	switch j.ResultConsumer {
+	case job.AwsConsumer:
+		providerDetails := job.ResultConsumerDetailsAws{}
+		if err := json.Unmarshal(j.ResultConsumerDetails, &providerDetails); err != nil {
+			return nil, err
+		}
+
+		return func(lCtx context.Context, file string, contentType string) error {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			return ctx.Instances().AwsS3.UploadFile(
+				lCtx,
+				providerDetails.Bucket,
+				path.Join(providerDetails.KeyFolder, path.Base(file)),
+				f,
+				utils.StringPointer(contentType),
+				aws.AclPublicRead,
+				aws.DefaultCacheControl,
+			)
+		}, nil
+	case job.LocalConsumer:
+		providerDetails := job.ResultConsumerDetailsLocal{}
+		if err := json.Unmarshal(j.ResultConsumerDetails, &providerDetails); err != nil {
+			return nil, err
+		}
+
+		if err := os.MkdirAll(providerDetails.PathFolder, 0700); err != nil {
+			return nil, err
+		}
+
+		return func(lCtx context.Context, file string, contentType string) error {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+
+			return os.WriteFile(path.Join(providerDetails.PathFolder, path.Base(file)), data, 0600)
+		}, nil
+	}
+
+	return func(lCtx context.Context, file string, contentType string) error {
+		return nil
+	}, nil
+}
+
+// uploads delivers files in the background while the rest of the task carries on.
+type uploads struct {
+	wg  sync.WaitGroup
+	mtx sync.Mutex
+	err error
+}
+
+func (u *uploads) start(upload func() error) {
+	u.wg.Add(1)
+	go func() {
+		defer u.wg.Done()
+
+		if err := upload(); err != nil {
+			u.mtx.Lock()
+			u.err = multierror.Append(u.err, err).ErrorOrNil()
+			u.mtx.Unlock()
+		}
+	}()
+}
+
+// wait blocks until every upload is done and returns all of their errors.
+func (u *uploads) wait() error {
+	u.wg.Wait()
+
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	return u.err
+}
@@ -0,0 +1,43 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/seventv/ImageProcessor/src/configure"
+	"github.com/seventv/ImageProcessor/src/global"
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_newDeliverer(t *testing.T) {
+	dir := t.TempDir()
+	out := path.Join(dir, "out")
+
+	details, _ := json.Marshal(job.ResultConsumerDetailsLocal{PathFolder: out})
+	deliver, err := newDeliverer(global.New(context.Background(), &configure.Config{}), job.Job{
+		ResultConsumer:        job.LocalConsumer,
+		ResultConsumerDetails: details,
+	})
+	assert.ErrorIs(t, err, nil, "no error when creating the deliverer")
+
+	file := path.Join(dir, "1x.webp")
+	_ = os.WriteFile(file, []byte("webp"), 0600)
+
+	pending := uploads{}
+	pending.start(func() error {
+		return deliver(context.Background(), file, "image/webp")
+	})
+	pending.start(func() error {
+		return fmt.Errorf("upload failed")
+	})
+
+	assert.Error(t, pending.wait(), "The error of a failed upload is returned")
+
+	data, err := os.ReadFile(path.Join(out, "1x.webp"))
+	assert.ErrorIs(t, err, nil, "The file was delivered")
+	assert.Equal(t, "webp", string(data), "The file was delivered")
+}
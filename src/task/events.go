@@ -2,12 +2,38 @@ package task
 
 import (
 	"time"
+
+	"github.com/seventv/ImageProcessor/src/image"
+	"github.com/seventv/ImageProcessor/src/job"
 )
 
 type TaskEvent struct {
 	JobID     string
 	Type      TaskEventType
 	Timestamp time.Time
+	// Progress is how far along the task is from 0 to 1, it is only set on events which move the task forward.
+	Progress float64 `json:",omitempty"`
+	// Detail describes what the event is about, it is only set on events which have something to describe.
+	Detail *EventDetail `json:",omitempty"`
+	// File is the output which was just delivered to the result consumer, it is only set on file-ready events.
+	File *job.File `json:",omitempty"`
+}
+
+type EventDetail struct {
+	// Type is the detected type of the input, set when stage one completes.
+	Type image.ImageType `json:",omitempty"`
+	// Frames is the number of frames of the input, set when stage one completes.
+	Frames int `json:",omitempty"`
+	// Size is the name of the size an output was made for.
+	Size   string `json:",omitempty"`
+	Width  int    `json:",omitempty"`
+	Height int    `json:",omitempty"`
+	// Format is the content type of an encoded output.
+	Format string `json:",omitempty"`
+	// Name is the file name of an encoded output.
+	Name string `json:",omitempty"`
+	// Bytes is the size of an encoded output.
+	Bytes int `json:",omitempty"`
 }
 
 type TaskEventType string
@@ -19,10 +45,22 @@ const (
 	Completed          TaskEventType = "completed"
 	Stopped            TaskEventType = "stopped"
 	Cleaned            TaskEventType = "cleaned"
+	CacheHit           TaskEventType = "cache-hit"
 	StageOne           TaskEventType = "stage-one"
 	StageOneComplete   TaskEventType = "stage-one-complete"
 	StageTwo           TaskEventType = "stage-two"
+	StageTwoSize       TaskEventType = "stage-two-size"
 	StageTwoComplete   TaskEventType = "stage-two-complete"
 	StageThree         TaskEventType = "stage-three"
+	StageThreeFile     TaskEventType = "stage-three-file"
 	StageThreeComplete TaskEventType = "stage-three-complete"
+	FileReady          TaskEventType = "file-ready"
+)
+
+// The share of the progress of a task each step is worth, uploading takes whatever is left.
+const (
+	progressDownloaded = 0.1
+	progressStageOne   = 0.2
+	progressStageTwo   = 0.2
+	progressStageThree = 0.4
 )
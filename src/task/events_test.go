@@ -0,0 +1,21 @@
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TaskEventJson(t *testing.T) {
+	ts := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	data, _ := json.Marshal(TaskEvent{JobID: "id", Type: Started, Timestamp: ts})
+	assert.Equal(t, `{"JobID":"id","Type":"started","Timestamp":"2022-01-01T00:00:00Z"}`, string(data), "Events without a payload look the same as before")
+
+	data, _ = json.Marshal(TaskEvent{JobID: "id", Type: StageThreeFile, Timestamp: ts, Progress: 0.5, Detail: &EventDetail{Size: "4x", Format: "image/webp", Bytes: 100}})
+	assert.Equal(t, `{"JobID":"id","Type":"stage-three-file","Timestamp":"2022-01-01T00:00:00Z","Progress":0.5,"Detail":{"Size":"4x","Format":"image/webp","Bytes":100}}`, string(data), "The payload is added to the event")
+
+	assert.Equal(t, "4x", sizeName("4x.avif"), "The size is the file name without the extension")
+	assert.Equal(t, "4x", sizeName("4x_static.webp"), "Thumbnails are named after their size")
+}
@@ -2,37 +2,132 @@ package task
 
 import (
 	"context"
-	"runtime"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/seventv/ImageProcessor/src/global"
+	"github.com/seventv/ImageProcessor/src/image"
 	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/seventv/ImageProcessor/src/monitoring"
+	"github.com/seventv/ImageProcessor/src/rmq"
+	"github.com/seventv/ImageProcessor/src/scheduler"
 	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
 )
 
-func Listen(ctx global.Context) {
-	msgCh, err := ctx.Instances().Rmq.Subscribe(ctx.Config().Rmq.JobQueueName)
-	if err != nil {
-		logrus.Fatal("failed to listen to jobs: ", err)
+func Listen(ctx global.Context, workers Workers) {
+	// a cancel is remembered for as long as a job could take so it also stops jobs which start late.
+	running := newRunningTasks(time.Second * time.Duration(ctx.Config().MaxTaskDuration))
+	go listenControl(ctx, running)
+
+	wg := sync.WaitGroup{}
+
+	for _, queue := range rmq.JobQueueNames(ctx.Config()) {
+		msgCh, err := ctx.Instances().Rmq.Subscribe(queue)
+		if err != nil {
+			logrus.Fatal("failed to listen to jobs: ", err)
+		}
+
+		// each queue is consumed on its own so bulk jobs waiting for a worker never hold up the priority queue.
+		wg.Add(1)
+		go func(l lane) {
+			defer wg.Done()
+
+			for msg := range msgCh {
+				worker, err := workers.get(ctx, l.priority)
+				if err != nil {
+					return
+				}
+
+				go worker.process(ctx, l, msg)
+			}
+		}(lane{
+			queue:    queue,
+			priority: queue == ctx.Config().Rmq.PriorityQueueName,
+			running:  running,
+		})
 	}
 
-	maxProcs := runtime.GOMAXPROCS(0)
-	workers := make(chan *taskWorker, maxProcs)
-	for i := 0; i < maxProcs; i++ {
-		workers <- &taskWorker{
-			cb: workers,
+	wg.Wait()
+}
+
+// lane is a job queue and how the jobs consumed from it are run.
+type lane struct {
+	queue    string
+	priority bool
+	running  *runningTasks
+}
+
+// Workers is the pool of task workers, some of which can be reserved for priority jobs.
+type Workers struct {
+	shared   chan *taskWorker
+	reserved chan *taskWorker
+}
+
+// NewWorkers creates n workers which all admit their tasks through s, reserved of them only run priority jobs.
+func NewWorkers(n int, reserved int, s *scheduler.Scheduler) Workers {
+	if reserved >= n {
+		reserved = n - 1
+	}
+	if reserved < 0 {
+		reserved = 0
+	}
+
+	workers := Workers{
+		shared:   make(chan *taskWorker, n-reserved),
+		reserved: make(chan *taskWorker, reserved),
+	}
+
+	for i := 0; i < n; i++ {
+		cb := workers.shared
+		if i < reserved {
+			cb = workers.reserved
+		}
+
+		cb <- &taskWorker{
+			cb:        cb,
+			scheduler: s,
+		}
+	}
+
+	monitoring.WorkersTotal(n)
+
+	return workers
+}
+
+// get waits for a free worker, only priority jobs can take one of the reserved workers.
+func (w Workers) get(ctx context.Context, priority bool) (*taskWorker, error) {
+	if !priority {
+		select {
+		case worker := <-w.shared:
+			return worker, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
 	}
 
-	for msg := range msgCh {
-		worker := <-workers
-		go worker.process(ctx, msg)
+	// priority jobs use the reserved workers first so the shared ones stay free for everything else.
+	select {
+	case worker := <-w.reserved:
+		return worker, nil
+	default:
+	}
+
+	select {
+	case worker := <-w.reserved:
+		return worker, nil
+	case worker := <-w.shared:
+		return worker, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
 type taskWorker struct {
-	cb chan *taskWorker
+	cb        chan *taskWorker
+	scheduler *scheduler.Scheduler
 }
 
 type RmqResult struct {
@@ -40,9 +135,16 @@ type RmqResult struct {
 	Success bool       `json:"success"`
 	Files   []job.File `json:"files"`
 	Error   string     `json:"error"`
+	// ErrorType is a short machine readable class of the error, for example input_too_large or timeout.
+	ErrorType string `json:"error_type,omitempty"`
+	// Trim is only set when the job asked for the frames to be trimmed.
+	Trim *image.Rect `json:"trim,omitempty"`
+	// PartialSuccess is set instead of Success when the job allows partial results and some of its outputs failed.
+	PartialSuccess bool          `json:"partial_success,omitempty"`
+	Failures       []job.Failure `json:"failures,omitempty"`
 }
 
-func (w *taskWorker) process(ctx global.Context, msg amqp.Delivery) {
+func (w *taskWorker) process(ctx global.Context, l lane, msg amqp.Delivery) {
 	ctx.AddTask(1)
 	defer func() {
 		ctx.DoneTask()
@@ -54,36 +156,15 @@ func (w *taskWorker) process(ctx global.Context, msg amqp.Delivery) {
 	err := json.Unmarshal(msg.Body, &j)
 	if err != nil {
 		logrus.Warn("bad job message: ", err)
+		// it can never succeed so it is dead lettered straight away rather than holding on to a prefetch slot.
+		settleFailure(ctx, l.queue, msg, "", fmt.Errorf("%w: %s", ErrBadJobMessage, err.Error()))
 		return
 	}
 
-	if len(j.AspectRatioXY) == 0 {
-		j.AspectRatioXY = []int{3, 1}
-	}
+	setDefaults(&j)
 
-	if j.Settings == 0 {
-		j.Settings = job.AllSettings
-	}
-
-	if len(j.Sizes) == 0 {
-		j.Sizes = map[string]job.ImageSize{
-			"4x": {
-				Width:  384,
-				Height: 128,
-			},
-			"3x": {
-				Width:  288,
-				Height: 96,
-			},
-			"2x": {
-				Width:  192,
-				Height: 64,
-			},
-			"1x": {
-				Width:  96,
-				Height: 32,
-			},
-		}
+	if l.priority && j.Priority < 1 {
+		j.Priority = 1
 	}
 
 	lCtx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(ctx.Config().MaxTaskDuration))
@@ -91,23 +172,41 @@ func (w *taskWorker) process(ctx global.Context, msg amqp.Delivery) {
 
 	task := New(lCtx, j)
 
-	task.Start(ctx)
+	if l.running.add(task) {
+		defer l.running.remove(task)
+	} else {
+		// the job was cancelled before it got here, the task only reports that it was stopped.
+		task.Stop()
+	}
 
 	logrus.Info("starting new task: ", j.ID)
 
-	for event := range task.Events() {
-		event.JobID = j.ID
-		event, _ := json.Marshal(event)
-		if err := ctx.Instances().Rmq.Publish(ctx.Config().Rmq.UpdateQueueName, "application/json", amqp.Transient, event); err != nil {
+	w.run(ctx, task, func(event TaskEvent) {
+		data, _ := json.Marshal(event)
+		if err := ctx.Instances().Rmq.Publish(ctx.Config().Rmq.UpdateQueueName, "application/json", amqp.Transient, data); err != nil {
 			logrus.Warn("failed to send update: ", err)
 		}
-	}
-	<-task.Done()
-	if err := task.Failed(); err != nil {
-		if err := msg.Reject(false); err != nil {
-			logrus.Warn("failed to ack: ", err)
+	})
+
+	if err := task.Failed(); err != nil && !task.Stopped() {
+		if ctx.Err() != nil {
+			// the task was only cut short because the processor is shutting down, so the job goes back as it was for the next processor.
+			logrus.Warn("requeueing task on shutdown: ", j.ID)
+			if err := msg.Nack(false, true); err != nil {
+				logrus.Warn("failed to nack: ", err)
+			}
+			return
+		}
+
+		// whatever the task was doing when it ran out of time, the error it failed with may look like a transport error.
+		if errors.Is(lCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("%w: %s", ErrTaskTimeout, err.Error())
 		}
+
 		logrus.Errorf("task failed %s: %s", j.ID, err.Error())
+		if !settleFailure(ctx, l.queue, msg, j.ID, err) {
+			return
+		}
 	} else {
 		if err := msg.Ack(false); err != nil {
 			logrus.Warn("failed to ack: ", err)
@@ -115,15 +214,25 @@ func (w *taskWorker) process(ctx global.Context, msg amqp.Delivery) {
 	}
 
 	errStr := ""
-	if task.Failed() != nil {
+	errType := ""
+	if task.Stopped() {
+		errStr = ErrCancelled.Error()
+		errType = errorClass(ErrCancelled)
+	} else if task.Failed() != nil {
 		errStr = task.Failed().Error()
+		errType = errorClass(task.Failed())
 	}
 
 	resp, _ := json.Marshal(RmqResult{
-		JobID:   j.ID,
-		Success: task.Failed() == nil,
-		Error:   errStr,
-		Files:   task.Files(),
+		JobID:     j.ID,
+		Success:   errStr == "" && len(task.Failures()) == 0,
+		Error:     errStr,
+		ErrorType: errType,
+		Files:     task.Files(),
+		Trim:      task.Trim(),
+
+		PartialSuccess: errStr == "" && len(task.Failures()) != 0,
+		Failures:       task.Failures(),
 	})
 
 	if err := ctx.Instances().Rmq.Publish(ctx.Config().Rmq.ResultQueueName, "application/json", amqp.Persistent, resp); err != nil {
@@ -132,3 +241,73 @@ func (w *taskWorker) process(ctx global.Context, msg amqp.Delivery) {
 
 	logrus.Info("finished task: ", j.ID)
 }
+
+// run starts the task and forwards every event it emits to onEvent, it returns once the task is done.
+func (w *taskWorker) run(ctx global.Context, task *Task, onEvent func(TaskEvent)) {
+	monitoring.WorkerBusy()
+	defer monitoring.WorkerIdle()
+
+	start := time.Now()
+	stages := stageTimer{}
+
+	monitoring.TaskStarted()
+	task.scheduler = w.scheduler
+	task.Start(ctx)
+
+	cached := false
+	for event := range task.Events() {
+		event.JobID = task.Job().ID
+		cached = cached || event.Type == CacheHit
+		stages.observe(event)
+		onEvent(event)
+	}
+	<-task.Done()
+
+	if task.Stopped() {
+		monitoring.TaskFailed(string(task.Type()), errorClass(ErrCancelled), time.Since(start))
+	} else if err := task.Failed(); err != nil {
+		monitoring.TaskFailed(string(task.Type()), errorClass(err), time.Since(start))
+	} else {
+		monitoring.TaskCompleted(string(task.Type()), time.Since(start))
+		// cached files were encoded by an earlier task, recording them again would skew the encoder metrics.
+		if cached {
+			monitoring.CacheHit(string(task.Type()))
+			return
+		}
+
+		for _, f := range task.Files() {
+			monitoring.EncoderOutput(f.ContentType, f.Animated, f.TimeTaken, f.Size)
+		}
+	}
+}
+
+func setDefaults(j *job.Job) {
+	if len(j.AspectRatioXY) == 0 {
+		j.AspectRatioXY = []int{3, 1}
+	}
+
+	if j.Settings == 0 {
+		j.Settings = job.AllSettings
+	}
+
+	if len(j.Sizes) == 0 {
+		j.Sizes = map[string]job.ImageSize{
+			"4x": {
+				Width:  384,
+				Height: 128,
+			},
+			"3x": {
+				Width:  288,
+				Height: 96,
+			},
+			"2x": {
+				Width:  192,
+				Height: 64,
+			},
+			"1x": {
+				Width:  96,
+				Height: 32,
+			},
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/seventv/ImageProcessor/src/aws"
+	"github.com/seventv/ImageProcessor/src/containers"
+	"github.com/seventv/ImageProcessor/src/download"
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/seventv/ImageProcessor/src/monitoring"
+)
+
+// stageTimer turns the stage start and complete events into stage durations.
+type stageTimer map[TaskEventType]time.Time
+
+func (s stageTimer) observe(event TaskEvent) {
+	switch event.Type {
+	case StageOne, StageTwo, StageThree:
+		s[event.Type] = event.Timestamp
+	case StageOneComplete:
+		monitoring.StageDuration("1", event.Timestamp.Sub(s[StageOne]))
+	case StageTwoComplete:
+		monitoring.StageDuration("2", event.Timestamp.Sub(s[StageTwo]))
+	case StageThreeComplete:
+		monitoring.StageDuration("3", event.Timestamp.Sub(s[StageThree]))
+	}
+}
+
+// errorClass buckets task errors into a small set of labels, it is also the error type reported in results.
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	case errors.Is(err, ErrUnknownJobProvider),
+		errors.Is(err, ErrBadJobMessage),
+		errors.Is(err, job.ErrBadEncodeOptions),
+		errors.Is(err, job.ErrBadTrim):
+		return "bad_job"
+	case errors.Is(err, job.ErrInputTooLarge),
+		errors.Is(err, download.ErrTooLarge):
+		return "input_too_large"
+	case errors.Is(err, download.ErrUnexpectedStatus),
+		errors.Is(err, download.ErrBadURL),
+		errors.Is(err, download.ErrTooManyRedirects),
+		errors.Is(err, download.ErrSizeMismatch),
+		errors.Is(err, download.ErrChecksumMismatch):
+		return "download"
+	case errors.Is(err, containers.ErrUnknownFormat):
+		return "unknown_format"
+	case errors.Is(err, job.ErrOverBudget):
+		return "over_budget"
+	case errors.Is(err, aws.ErrNoSuchKey),
+		errors.Is(err, aws.ErrUploadFailed),
+		errors.Is(err, aws.ErrDownloadFailed):
+		return "storage"
+	}
+
+	return "processing"
+}
@@ -0,0 +1,99 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/seventv/ImageProcessor/src/aws"
+	"github.com/seventv/ImageProcessor/src/download"
+	"github.com/seventv/ImageProcessor/src/global"
+	"github.com/seventv/ImageProcessor/src/rmq"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// retryable reports if a failure might go away by itself, anything caused by the job or its input never will.
+func retryable(err error) bool {
+	switch {
+	// a job which runs out of time is too slow and would only run out of time again, transport timeouts are net errors.
+	case errors.Is(err, ErrTaskTimeout),
+		errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, download.ErrServerStatusError):
+		return true
+	case errors.Is(err, aws.ErrNoSuchKey):
+		return false
+	case errors.Is(err, aws.ErrUploadFailed),
+		errors.Is(err, aws.ErrDownloadFailed):
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// settleFailure either schedules the job for another attempt on the job queue it came from or moves it to the dead letter queue.
+// It returns false when the job was retried or handed back to the broker and so has no final result yet.
+func settleFailure(ctx global.Context, queue string, msg amqp.Delivery, jobID string, err error) bool {
+	// the broker already redelivers a job received before a reconnect, publishing a retry of it as well would run it twice.
+	if !ctx.Instances().Rmq.Current(msg) {
+		logrus.Warn("not settling task received before a reconnect: ", jobID)
+		return false
+	}
+
+	attempts := rmq.Attempts(msg.Headers) + 1
+
+	if retryable(err) && attempts < rmq.MaxAttempts(ctx.Config()) {
+		if pErr := ctx.Instances().Rmq.PublishMessage(rmq.RetryQueueName(ctx.Config(), queue, attempts), amqp.Publishing{
+			ContentType:  msg.ContentType,
+			DeliveryMode: amqp.Persistent,
+			Timestamp:    time.Now(),
+			Body:         msg.Body,
+			Headers: amqp.Table{
+				rmq.HeaderAttempts:  int32(attempts),
+				rmq.HeaderError:     err.Error(),
+				rmq.HeaderErrorType: errorClass(err),
+			},
+		}); pErr != nil {
+			// leave it to the broker to redeliver the job rather than losing it.
+			logrus.Error("failed to schedule retry: ", pErr)
+			if err := msg.Reject(true); err != nil {
+				logrus.Warn("failed to reject: ", err)
+			}
+			return false
+		}
+
+		logrus.Warnf("retrying task %s in %s, attempt %d of %d", jobID, rmq.RetryDelay(ctx.Config(), attempts), attempts+1, rmq.MaxAttempts(ctx.Config()))
+		if err := msg.Ack(false); err != nil {
+			logrus.Warn("failed to ack: ", err)
+		}
+		return false
+	}
+
+	if pErr := ctx.Instances().Rmq.PublishMessage(rmq.DeadLetterQueueName(ctx.Config()), amqp.Publishing{
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		Body:         msg.Body,
+		Headers: amqp.Table{
+			rmq.HeaderAttempts:  int32(attempts),
+			rmq.HeaderError:     err.Error(),
+			rmq.HeaderErrorType: errorClass(err),
+		},
+	}); pErr != nil {
+		// the job is handed back to the broker, acking it here would lose it for good.
+		logrus.Error("failed to dead letter job: ", pErr)
+		if err := msg.Nack(false, true); err != nil {
+			logrus.Warn("failed to nack: ", err)
+		}
+		return false
+	}
+
+	if err := msg.Ack(false); err != nil {
+		logrus.Warn("failed to ack: ", err)
+	}
+
+	return true
+}
@@ -0,0 +1,18 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/seventv/ImageProcessor/src/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_retryable(t *testing.T) {
+	assert.True(t, retryable(fmt.Errorf("%w, connection reset", aws.ErrUploadFailed)), "A failed upload is retried")
+	assert.False(t, retryable(fmt.Errorf("%w: %s", ErrTaskTimeout, aws.ErrUploadFailed)), "A task which ran out of time is not retried")
+	assert.False(t, retryable(&url.Error{Op: "Get", URL: "http://a", Err: context.DeadlineExceeded}), "A download cut short by the task deadline is not retried")
+	assert.False(t, retryable(ErrBadJobMessage), "A bad job is not retried")
+}
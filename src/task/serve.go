@@ -0,0 +1,294 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/seventv/ImageProcessor/src/global"
+	"github.com/seventv/ImageProcessor/src/image"
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/sirupsen/logrus"
+)
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobCompleted JobState = "completed"
+	JobFailed    JobState = "failed"
+	JobStopped   JobState = "stopped"
+	// JobPartial is a completed job which allows partial results and is missing some of its outputs.
+	JobPartial JobState = "partial"
+)
+
+type ApiJob struct {
+	ID     string      `json:"id"`
+	State  JobState    `json:"state"`
+	Events []TaskEvent `json:"events"`
+	Files  []job.File  `json:"files"`
+	Error  string      `json:"error,omitempty"`
+	// ErrorType is a short machine readable class of the error, for example input_too_large or timeout.
+	ErrorType string      `json:"error_type,omitempty"`
+	Trim      *image.Rect `json:"trim,omitempty"`
+	// Failures are the outputs which could not be made in a partial job.
+	Failures []job.Failure `json:"failures,omitempty"`
+}
+
+type apiEntry struct {
+	mtx      sync.Mutex
+	job      job.Job
+	state    JobState
+	task     *Task
+	events   []TaskEvent
+	files    []job.File
+	trim     *image.Rect
+	failures []job.Failure
+	err      string
+	errType  string
+}
+
+func (e *apiEntry) toApiJob() ApiJob {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	return ApiJob{
+		ID:        e.job.ID,
+		State:     e.state,
+		Events:    append([]TaskEvent{}, e.events...),
+		Files:     e.files,
+		Error:     e.err,
+		ErrorType: e.errType,
+		Trim:      e.trim,
+		Failures:  e.failures,
+	}
+}
+
+type apiServer struct {
+	ctx     global.Context
+	workers Workers
+
+	mtx  sync.Mutex
+	jobs map[string]*apiEntry
+}
+
+// Serve exposes the job api over http, jobs submitted here are processed by the same workers as jobs consumed from rmq.
+func Serve(ctx global.Context, workers Workers) {
+	s := &apiServer{
+		ctx:     ctx,
+		workers: workers,
+		jobs:    map[string]*apiEntry{},
+	}
+
+	bind := ctx.Config().Api.Bind
+	if bind == "" {
+		// the api has no authentication so it is only reachable from the host unless a bind is configured.
+		bind = "127.0.0.1:3000"
+	}
+
+	srv := &http.Server{
+		Addr:    bind,
+		Handler: s.handler(),
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	logrus.Info("job api listening on ", bind)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logrus.Fatal("failed to serve job api: ", err)
+	}
+}
+
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", s.handleJobs)
+	mux.HandleFunc("/jobs/", s.handleJob)
+
+	return mux
+}
+
+func (s *apiServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJson(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	if s.ctx.Err() != nil {
+		writeJson(w, http.StatusServiceUnavailable, map[string]string{"error": "shutting down"})
+		return
+	}
+
+	j := job.Job{}
+	if err := json.NewDecoder(r.Body).Decode(&j); err != nil {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "bad job: " + err.Error()})
+		return
+	}
+
+	// anyone who can reach the api could otherwise read and write any file the processor can.
+	if j.RawProvider == job.LocalProvider || j.ResultConsumer == job.LocalConsumer {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "bad job: local providers and consumers are not allowed over http"})
+		return
+	}
+
+	if j.ID == "" {
+		id, _ := uuid.NewRandom()
+		j.ID = id.String()
+	}
+
+	setDefaults(&j)
+
+	entry := &apiEntry{
+		job:   j,
+		state: JobQueued,
+	}
+
+	s.mtx.Lock()
+	if _, ok := s.jobs[j.ID]; ok {
+		s.mtx.Unlock()
+		writeJson(w, http.StatusConflict, map[string]string{"error": "job already exists"})
+		return
+	}
+	s.jobs[j.ID] = entry
+	s.mtx.Unlock()
+
+	s.ctx.AddTask(1)
+	go s.process(entry)
+
+	writeJson(w, http.StatusAccepted, entry.toApiJob())
+}
+
+func (s *apiServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+
+	s.mtx.Lock()
+	entry, ok := s.jobs[id]
+	s.mtx.Unlock()
+
+	if !ok {
+		writeJson(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJson(w, http.StatusOK, entry.toApiJob())
+	case http.MethodDelete:
+		var task *Task
+
+		entry.mtx.Lock()
+		switch entry.state {
+		case JobQueued:
+			entry.state = JobStopped
+			entry.events = append(entry.events, TaskEvent{
+				JobID:     id,
+				Type:      Stopped,
+				Timestamp: time.Now(),
+			})
+		case JobRunning:
+			task = entry.task
+		}
+		entry.mtx.Unlock()
+
+		// stopping emits an event which is consumed while holding the entry lock.
+		if task != nil {
+			task.Stop()
+		}
+
+		writeJson(w, http.StatusOK, entry.toApiJob())
+	default:
+		writeJson(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+func (s *apiServer) process(entry *apiEntry) {
+	defer s.ctx.DoneTask()
+	defer s.expire(entry)
+
+	worker, err := s.workers.get(s.ctx, entry.job.Priority > 0)
+	if err != nil {
+		entry.mtx.Lock()
+		if entry.state == JobQueued {
+			entry.state = JobStopped
+		}
+		entry.mtx.Unlock()
+		return
+	}
+	defer func() {
+		worker.cb <- worker
+	}()
+
+	lCtx, cancel := context.WithTimeout(s.ctx, time.Second*time.Duration(s.ctx.Config().MaxTaskDuration))
+	defer cancel()
+
+	entry.mtx.Lock()
+	if entry.state != JobQueued {
+		entry.mtx.Unlock()
+		return
+	}
+
+	task := New(lCtx, entry.job)
+	entry.task = task
+	entry.state = JobRunning
+	entry.mtx.Unlock()
+
+	logrus.Info("starting new task: ", entry.job.ID)
+
+	worker.run(s.ctx, task, func(event TaskEvent) {
+		entry.mtx.Lock()
+		entry.events = append(entry.events, event)
+		entry.mtx.Unlock()
+	})
+
+	entry.mtx.Lock()
+	if task.Stopped() {
+		entry.state = JobStopped
+	} else if err := task.Failed(); err != nil {
+		entry.state = JobFailed
+		entry.err = err.Error()
+		entry.errType = errorClass(err)
+		logrus.Errorf("task failed %s: %s", entry.job.ID, err.Error())
+	} else {
+		entry.state = JobCompleted
+		entry.files = task.Files()
+		entry.trim = task.Trim()
+		entry.failures = task.Failures()
+		if len(entry.failures) != 0 {
+			entry.state = JobPartial
+		}
+	}
+	entry.mtx.Unlock()
+
+	logrus.Info("finished task: ", entry.job.ID)
+}
+
+// expire forgets about a finished job once the retention period has passed so the job list does not grow forever.
+func (s *apiServer) expire(entry *apiEntry) {
+	retention := time.Second * time.Duration(s.ctx.Config().Api.Retention)
+	if retention <= 0 {
+		retention = time.Hour
+	}
+
+	time.AfterFunc(retention, func() {
+		s.mtx.Lock()
+		delete(s.jobs, entry.job.ID)
+		s.mtx.Unlock()
+	})
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	data, _ := json.Marshal(v)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
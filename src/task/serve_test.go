@@ -0,0 +1,112 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/seventv/ImageProcessor/src/configure"
+	"github.com/seventv/ImageProcessor/src/global"
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/stretchr/testify/assert"
+)
+
+func testApiServer(t *testing.T, workers Workers) (*apiServer, *httptest.Server, context.CancelFunc) {
+	config := &configure.Config{}
+	config.WorkingDir = t.TempDir()
+	config.MaxTaskDuration = 60
+	config.Api.Retention = 1
+
+	c, cancel := context.WithCancel(context.Background())
+	s := &apiServer{
+		ctx:     global.New(c, config),
+		workers: workers,
+		jobs:    map[string]*apiEntry{},
+	}
+
+	srv := httptest.NewServer(s.handler())
+	t.Cleanup(srv.Close)
+	t.Cleanup(cancel)
+
+	return s, srv, cancel
+}
+
+func testRequest(t *testing.T, method string, url string, body interface{}) (int, ApiJob) {
+	data, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, url, bytes.NewReader(data))
+
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.ErrorIs(t, err, nil, "no error when requesting") {
+		return 0, ApiJob{}
+	}
+	defer resp.Body.Close()
+
+	out := ApiJob{}
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+
+	return resp.StatusCode, out
+}
+
+func Test_apiServerQueued(t *testing.T) {
+	// without any workers every job stays queued.
+	s, srv, cancel := testApiServer(t, NewWorkers(0, 0, nil))
+
+	status, out := testRequest(t, http.MethodPost, srv.URL+"/jobs", job.Job{ID: "queued"})
+	assert.Equal(t, http.StatusAccepted, status, "The job was accepted")
+	assert.Equal(t, JobQueued, out.State, "The job is queued")
+
+	status, _ = testRequest(t, http.MethodPost, srv.URL+"/jobs", job.Job{ID: "queued"})
+	assert.Equal(t, http.StatusConflict, status, "The same job cannot be queued twice")
+
+	status, _ = testRequest(t, http.MethodPost, srv.URL+"/jobs", job.Job{ID: "local", RawProvider: job.LocalProvider})
+	assert.Equal(t, http.StatusBadRequest, status, "Local files cannot be read over http")
+
+	status, _ = testRequest(t, http.MethodPost, srv.URL+"/jobs", job.Job{ID: "local", ResultConsumer: job.LocalConsumer})
+	assert.Equal(t, http.StatusBadRequest, status, "Local files cannot be written over http")
+
+	status, _ = testRequest(t, http.MethodGet, srv.URL+"/jobs", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, status, "Jobs can only be posted")
+
+	status, out = testRequest(t, http.MethodGet, srv.URL+"/jobs/queued", nil)
+	assert.Equal(t, http.StatusOK, status, "The job can be fetched")
+	assert.Equal(t, JobQueued, out.State, "The job is still queued")
+
+	status, out = testRequest(t, http.MethodDelete, srv.URL+"/jobs/queued", nil)
+	assert.Equal(t, http.StatusOK, status, "The job can be stopped")
+	assert.Equal(t, JobStopped, out.State, "The job was stopped before it ran")
+	assert.Equal(t, Stopped, out.Events[len(out.Events)-1].Type, "Stopping the job adds a stopped event")
+
+	status, _ = testRequest(t, http.MethodGet, srv.URL+"/jobs/missing", nil)
+	assert.Equal(t, http.StatusNotFound, status, "An unknown job is not found")
+
+	// the job gives up waiting for a worker on shutdown and is forgotten once the retention has passed.
+	cancel()
+	assert.Eventually(t, func() bool {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+
+		return len(s.jobs) == 0
+	}, time.Second*5, time.Millisecond*100, "The job expired")
+
+	status, _ = testRequest(t, http.MethodGet, srv.URL+"/jobs/queued", nil)
+	assert.Equal(t, http.StatusNotFound, status, "An expired job is not found")
+}
+
+func Test_apiServerFailed(t *testing.T) {
+	_, srv, _ := testApiServer(t, NewWorkers(1, 0, nil))
+
+	status, out := testRequest(t, http.MethodPost, srv.URL+"/jobs", job.Job{})
+	assert.Equal(t, http.StatusAccepted, status, "The job was accepted")
+	assert.NotEmpty(t, out.ID, "The job was given an id")
+
+	assert.Eventually(t, func() bool {
+		_, out = testRequest(t, http.MethodGet, srv.URL+"/jobs/"+out.ID, nil)
+		return out.State == JobFailed
+	}, time.Second*5, time.Millisecond*100, "The job without a provider failed")
+
+	assert.Equal(t, ErrUnknownJobProvider.Error(), out.Error, "The error is reported")
+	assert.Equal(t, Started, out.Events[0].Type, "The events of the task are kept")
+}
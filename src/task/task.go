@@ -2,12 +2,12 @@ package task
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/fs"
-	"mime"
 	"os"
 	"path"
-	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,18 +15,25 @@ import (
 	"github.com/google/uuid"
 	"github.com/hashicorp/go-multierror"
 	jsoniter "github.com/json-iterator/go"
-	"github.com/seventv/ImageProcessor/src/aws"
+	"github.com/seventv/ImageProcessor/src/cache"
 	"github.com/seventv/ImageProcessor/src/containers"
+	"github.com/seventv/ImageProcessor/src/download"
 	"github.com/seventv/ImageProcessor/src/global"
 	"github.com/seventv/ImageProcessor/src/image"
 	"github.com/seventv/ImageProcessor/src/job"
-	"github.com/seventv/ImageProcessor/src/utils"
+	"github.com/seventv/ImageProcessor/src/manifest"
+	"github.com/seventv/ImageProcessor/src/scheduler"
 	"github.com/sirupsen/logrus"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
-var ErrUnknownJobProvider = fmt.Errorf("unknown job provider")
+var (
+	ErrUnknownJobProvider = fmt.Errorf("unknown job provider")
+	ErrCancelled          = fmt.Errorf("job was cancelled: %w", context.Canceled)
+	ErrBadJobMessage      = fmt.Errorf("bad job message")
+	ErrTaskTimeout        = fmt.Errorf("task took longer than the max task duration: %w", context.DeadlineExceeded)
+)
 
 type Task struct {
 	id uuid.UUID
@@ -41,7 +48,15 @@ type Task struct {
 
 	dir string
 
-	files []job.File
+	imgType image.ImageType
+	trim    *image.Rect
+	files   []job.File
+	source  manifest.Source
+	// failures are the outputs which could not be made when the job allows partial results.
+	failures []job.Failure
+
+	// scheduler admits the task to the heavy stages once its input has been probed, nil admits it straight away.
+	scheduler *scheduler.Scheduler
 
 	events chan TaskEvent
 
@@ -68,6 +83,13 @@ func (t *Task) ID() uuid.UUID {
 func (t *Task) Start(ctx global.Context) {
 	t.mtx.Lock()
 	defer t.mtx.Unlock()
+	if t.stopped && !t.started && !t.completed {
+		// the task was stopped before it ever ran so nothing else will close the events.
+		t.completed = true
+		close(t.events)
+		return
+	}
+
 	if t.started || t.stopped || t.completed {
 		return
 	}
@@ -82,8 +104,10 @@ func (t *Task) start(ctx global.Context) {
 	defer func() {
 		if err := recover(); err != nil && t.failed == nil {
 			logrus.Error("panic to cleanup: ", err)
+			t.mtx.Lock()
 			t.completed = true
 			t.failed = fmt.Errorf("%v", err)
+			t.mtx.Unlock()
 			t.cancel()
 			t.events <- TaskEvent{
 				JobID:     t.job.ID,
@@ -103,10 +127,52 @@ func (t *Task) start(ctx global.Context) {
 	}
 
 	var (
-		err  error
-		data []byte
+		err           error
+		data          []byte
+		encodeOptions job.EncodeOptions
+		limits        job.Limits
+		store         cache.Cache
+		cacheKey      string
+		cached        *cache.Entry
+		release       func()
+		deliver       deliverer
+		pending       uploads
+		manifestFile  string
 	)
 
+	// failed tasks hand their share of the budget back here, successful ones as soon as stage 3 is done.
+	defer func() {
+		if release != nil {
+			release()
+		}
+	}()
+
+	dir := path.Join(ctx.Config().WorkingDir, t.id.String())
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		goto completed
+	}
+
+	t.dir = dir
+
+	encodeOptions = t.job.EncodeOptions.WithDefaults(ctx.Config().Encode)
+	if err = encodeOptions.Validate(); err != nil {
+		goto completed
+	}
+
+	if err = t.job.Trim.Validate(); err != nil {
+		goto completed
+	}
+
+	limits = t.job.Limits.Within(ctx.Config().Limits.WithDefaults(job.DefaultLimits))
+
+	if store, err = cache.New(ctx.Config(), ctx.Instances().AwsS3); err != nil {
+		goto completed
+	}
+
+	if deliver, err = newDeliverer(ctx, t.job); err != nil {
+		goto completed
+	}
+
 	switch t.job.RawProvider {
 	case job.AwsProvider:
 		providerDetails := job.RawProviderDetailsAws{}
@@ -114,38 +180,100 @@ func (t *Task) start(ctx global.Context) {
 			goto completed
 		}
 
+		// the size is checked up front so an oversized object is never held in memory.
+		var size int64
+		if size, err = ctx.Instances().AwsS3.ObjectSize(t.ctx, providerDetails.Bucket, providerDetails.Key); err != nil {
+			goto completed
+		}
+
+		if err = limits.CheckBytes(size); err != nil {
+			goto completed
+		}
+
 		buf := Aws.NewWriteAtBuffer([]byte{})
 		if err = ctx.Instances().AwsS3.DownloadFile(t.ctx, providerDetails.Bucket, providerDetails.Key, buf); err != nil {
 			goto completed
 		}
 
 		data = buf.Bytes()
+		if err = limits.CheckBytes(int64(len(data))); err != nil {
+			goto completed
+		}
 	case job.LocalProvider:
 		providerDetails := job.RawProviderDetailsLocal{}
 		if err = json.Unmarshal(t.job.RawProviderDetails, &providerDetails); err != nil {
 			goto completed
 		}
 
+		var info fs.FileInfo
+		if info, err = os.Stat(providerDetails.Path); err != nil {
+			goto completed
+		}
+
+		if err = limits.CheckBytes(info.Size()); err != nil {
+			goto completed
+		}
+
 		if data, err = os.ReadFile(providerDetails.Path); err != nil {
 			goto completed
 		}
+	case job.HttpProvider:
+		providerDetails := job.RawProviderDetailsHttp{}
+		if err = json.Unmarshal(t.job.RawProviderDetails, &providerDetails); err != nil {
+			goto completed
+		}
+
+		opts := download.OptionsFromConfig(ctx.Config())
+		if limits.MaxBytes > 0 && limits.MaxBytes < opts.MaxSize {
+			opts.MaxSize = limits.MaxBytes
+		}
+
+		rawFile := path.Join(dir, "raw")
+		if err = download.Http(t.ctx, opts, providerDetails, rawFile); err != nil {
+			goto completed
+		}
+
+		if data, err = os.ReadFile(rawFile); err != nil {
+			goto completed
+		}
 	default:
 		err = ErrUnknownJobProvider
 		goto completed
 	}
 
-	t.events <- TaskEvent{
-		JobID:     t.job.ID,
-		Type:      Downloaed,
-		Timestamp: time.Now(),
-	}
+	t.emit(Downloaed, progressDownloaded, nil)
 
 	if t.ctx.Err() != nil {
 		err = t.ctx.Err()
 		goto completed
 	}
 
-	{
+	if store != nil {
+		if cacheKey, err = cache.Key(data, t.job, encodeOptions); err != nil {
+			goto completed
+		}
+
+		if cached, err = store.Fetch(t.ctx, cacheKey, dir); err == nil {
+			t.imgType = cached.Type
+			t.files = cached.Files
+			t.trim = cached.Trim
+			t.source = cached.Source
+
+			t.emit(CacheHit, progressDownloaded+progressStageOne+progressStageTwo+progressStageThree, nil)
+
+			for _, f := range t.files {
+				t.deliver(deliver, &pending, f)
+			}
+		} else {
+			if !errors.Is(err, cache.ErrMiss) {
+				logrus.Warn("failed to fetch from cache: ", err)
+			}
+			cached = nil
+			err = nil
+		}
+	}
+
+	if cached == nil {
 		var imgType image.ImageType
 
 		// we now have to figure out what we have??
@@ -153,12 +281,8 @@ func (t *Task) start(ctx global.Context) {
 			goto completed
 		}
 
-		dir := path.Join(ctx.Config().WorkingDir, t.id.String())
-		if err = os.MkdirAll(dir, 0700); err != nil {
-			goto completed
-		}
-
-		t.dir = dir
+		t.imgType = imgType
+		t.source.Type = imgType
 
 		fileName := path.Join(dir, fmt.Sprintf("raw.%s", imgType))
 		if err = os.WriteFile(fileName, data, 0600); err != nil {
@@ -181,138 +305,129 @@ func (t *Task) start(ctx global.Context) {
 		}
 
 		var img *image.Image
-		if img, err = containers.ProcessStage1(t.ctx, ctx.Config(), fileName, imgType, aspectRatioXy); err != nil {
-			goto completed
-		}
+		if img, err = containers.ProcessStage1(t.ctx, ctx.Config(), fileName, imgType, aspectRatioXy, t.job.Framing, t.job.Trim, limits, func(width, height, frames int) (int, error) {
+			t.source.Width = width
+			t.source.Height = height
 
-		t.events <- TaskEvent{
-			JobID:     t.job.ID,
-			Type:      StageOneComplete,
-			Timestamp: time.Now(),
-		}
+			if t.scheduler == nil {
+				return 0, nil
+			}
 
-		t.events <- TaskEvent{
-			JobID:     t.job.ID,
-			Type:      StageTwo,
-			Timestamp: time.Now(),
-		}
+			cost := scheduler.Estimate(width, height, frames, t.job.Settings)
+			r, err := t.scheduler.Acquire(t.ctx, cost, t.job.Priority)
+			if err != nil {
+				return 0, err
+			}
+			release = r
 
-		if err = containers.ProcessStage2(t.ctx, ctx.Config(), img, t.job.Sizes); err != nil {
+			return t.scheduler.Threads(cost), nil
+		}); err != nil {
 			goto completed
 		}
-
-		t.events <- TaskEvent{
-			JobID:     t.job.ID,
-			Type:      StageTwoComplete,
-			Timestamp: time.Now(),
+		t.trim = img.Trim
+		t.source.Frames = len(img.Delays)
+		for _, d := range img.Delays {
+			t.source.Duration += d
 		}
 
+		t.emit(StageOneComplete, progressDownloaded+progressStageOne, &EventDetail{
+			Type:   imgType,
+			Frames: len(img.Delays),
+			Width:  int(img.Width),
+			Height: int(img.Height),
+		})
+
 		t.events <- TaskEvent{
 			JobID:     t.job.ID,
-			Type:      StageThree,
+			Type:      StageTwo,
 			Timestamp: time.Now(),
 		}
 
-		if t.files, err = containers.ProcessStage3(t.ctx, ctx.Config(), img, t.job.Sizes, t.job.Settings); err != nil {
+		sizesDone := 0
+		sizesMtx := sync.Mutex{}
+		if err = containers.ProcessStage2(t.ctx, ctx.Config(), img, t.job.Sizes, t.job.Framing, func(name string) {
+			sizesMtx.Lock()
+			defer sizesMtx.Unlock()
+
+			sizesDone++
+			t.emit(StageTwoSize, progressDownloaded+progressStageOne+progressStageTwo*float64(sizesDone)/float64(len(t.job.Sizes)), &EventDetail{
+				Size: name,
+			})
+		}); err != nil {
 			goto completed
 		}
 
+		t.emit(StageTwoComplete, progressDownloaded+progressStageOne+progressStageTwo, nil)
+
 		t.events <- TaskEvent{
 			JobID:     t.job.ID,
-			Type:      StageThreeComplete,
+			Type:      StageThree,
 			Timestamp: time.Now(),
 		}
 
-		files := []string{}
-		if err = filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
-			if info.IsDir() {
-				return nil
+		filesDone := 0
+		if t.files, err = containers.ProcessStage3(t.ctx, ctx.Config(), img, t.job.Sizes, t.job.Settings, encodeOptions, func(file job.File, total int) {
+			filesDone++
+			t.emit(StageThreeFile, progressDownloaded+progressStageOne+progressStageTwo+progressStageThree*float64(filesDone)/float64(total), &EventDetail{
+				Size:   sizeName(file.Name),
+				Width:  file.Width,
+				Height: file.Height,
+				Format: file.ContentType,
+				Name:   file.Name,
+				Bytes:  file.Size,
+			})
+			t.deliver(deliver, &pending, file)
+		}); err != nil {
+			failures, ok := partialFailures(t.ctx, err, t.files)
+			if !t.job.AllowPartial || !ok {
+				goto completed
 			}
 
-			if filepath.Ext(path) == ".webp" {
-				files = append(files, path)
-			} else if filepath.Ext(path) == ".png" {
-				files = append(files, path)
-			} else if filepath.Ext(path) == ".gif" {
-				files = append(files, path)
-			} else if filepath.Ext(path) == ".avif" {
-				files = append(files, path)
-			}
+			logrus.Warnf("task %s is missing %d outputs: %s", t.job.ID, len(failures), err.Error())
+			t.failures = failures
+			err = nil
+		}
 
-			return nil
-		}); err != nil {
-			goto completed
+		// uploading is not limited by the cpu so other tasks can start encoding.
+		if release != nil {
+			release()
 		}
 
-		switch t.job.ResultConsumer {
-		case job.AwsConsumer:
-			providerDetails := job.ResultConsumerDetailsAws{}
-			if err = json.Unmarshal(t.job.ResultConsumerDetails, &providerDetails); err != nil {
-				goto completed
-			}
-			errCh := make(chan error)
-			wg := sync.WaitGroup{}
-			wg.Add(len(files))
-			for _, v := range files {
-				go func(v string) {
-					defer wg.Done()
-					f, err := os.Open(v)
-					if err != nil {
-						errCh <- err
-						return
-					}
-					defer f.Close()
-					errCh <- ctx.Instances().AwsS3.UploadFile(
-						t.ctx,
-						providerDetails.Bucket,
-						path.Join(providerDetails.KeyFolder, path.Base(v)),
-						f,
-						utils.StringPointer(mime.TypeByExtension(path.Ext(v))),
-						aws.AclPublicRead,
-						aws.DefaultCacheControl,
-					)
-				}(v)
-			}
-			go func() {
-				wg.Wait()
-				close(errCh)
-			}()
+		t.emit(StageThreeComplete, progressDownloaded+progressStageOne+progressStageTwo+progressStageThree, nil)
+	}
 
-			for e := range errCh {
-				err = multierror.Append(err, e).ErrorOrNil()
-			}
-			if err != nil {
-				goto completed
-			}
-		case job.LocalConsumer:
-			providerDetails := job.ResultConsumerDetailsLocal{}
-			if err = json.Unmarshal(t.job.ResultConsumerDetails, &providerDetails); err != nil {
-				goto completed
-			}
+	// every output is delivered as soon as it is encoded, so all that is left is to wait for the last of them.
+	if err = pending.wait(); err != nil {
+		goto completed
+	}
 
-			err = os.MkdirAll(providerDetails.PathFolder, 0700)
-			if err != nil {
-				goto completed
-			}
+	// the manifest is delivered last so once it is there every output it lists is too.
+	if manifestFile, err = t.writeManifest(); err != nil {
+		goto completed
+	}
 
-			var f []byte
-			for _, v := range files {
-				f, err = os.ReadFile(v)
-				if err != nil {
-					goto completed
-				}
-
-				err = os.WriteFile(path.Join(providerDetails.PathFolder, path.Base(v)), f, 0600)
-				if err != nil {
-					goto completed
-				}
-			}
+	if err = deliver(t.ctx, manifestFile, "application/json"); err != nil {
+		goto completed
+	}
+
+	// a partial result is not cached, the next identical job gets another chance at the missing outputs.
+	if store != nil && cached == nil && len(t.failures) == 0 {
+		// failing to cache the outputs does not fail the job, it only means the next identical job does the work again.
+		if err := store.Store(t.ctx, cacheKey, cache.Entry{Type: t.imgType, Files: t.files, Trim: t.trim, Source: t.source}, dir); err != nil {
+			logrus.Warn("failed to store in cache: ", err)
 		}
 	}
 
 completed:
+	// uploads which are still running read from the working directory, which is removed once the task is done.
+	if uErr := pending.wait(); err == nil {
+		err = uErr
+	}
+
+	t.mtx.Lock()
 	t.completed = true
 	t.failed = err
+	t.mtx.Unlock()
 	t.cancel()
 	if err != nil {
 		t.events <- TaskEvent{
@@ -321,17 +436,92 @@ completed:
 			Timestamp: time.Now(),
 		}
 	} else {
+		t.emit(Completed, 1, nil)
+	}
+}
+
+// writeManifest writes the manifest of the outputs into the working directory.
+func (t *Task) writeManifest() (string, error) {
+	m, err := manifest.New(t.job.ID, t.source, t.files, t.failures, t.dir)
+	if err != nil {
+		return "", err
+	}
+
+	return m.Write(t.dir)
+}
+
+// emit sends an event for the task, the progress and detail are left out when they are zero.
+func (t *Task) emit(eventType TaskEventType, progress float64, detail *EventDetail) {
+	t.events <- TaskEvent{
+		JobID:     t.job.ID,
+		Type:      eventType,
+		Timestamp: time.Now(),
+		Progress:  progress,
+		Detail:    detail,
+	}
+}
+
+// deliver hands the file to the result consumer in the background and emits a file-ready event once it is there.
+func (t *Task) deliver(deliver deliverer, pending *uploads, file job.File) {
+	pending.start(func() error {
+		if err := deliver(t.ctx, path.Join(t.dir, file.Name), file.ContentType); err != nil {
+			return err
+		}
+
 		t.events <- TaskEvent{
 			JobID:     t.job.ID,
-			Type:      Completed,
+			Type:      FileReady,
 			Timestamp: time.Now(),
+			File:      &file,
+		}
+
+		return nil
+	})
+}
+
+// partialFailures turns the error of stage 3 into a failure per output.
+// It is only a partial success when every error belongs to a single output, at least one output was made and the task was not stopped or timed out.
+func partialFailures(ctx context.Context, err error, files []job.File) ([]job.Failure, bool) {
+	if ctx.Err() != nil || len(files) == 0 {
+		return nil, false
+	}
+
+	errs := []error{err}
+	merr := &multierror.Error{}
+	if errors.As(err, &merr) {
+		errs = merr.Errors
+	}
+
+	failures := []job.Failure{}
+	for _, e := range errs {
+		outErr := &containers.OutputError{}
+		if !errors.As(e, &outErr) {
+			return nil, false
 		}
+
+		failures = append(failures, job.Failure{
+			Format: outErr.Format,
+			Size:   outErr.Size,
+			Name:   outErr.Name,
+			Error:  outErr.Err.Error(),
+		})
 	}
+
+	return failures, true
+}
+
+// sizeName is the size an output file was made for, outputs are named after their size with an optional _static suffix.
+func sizeName(file string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(file, path.Ext(file)), "_static")
 }
 
 func (t *Task) Stop() {
 	t.mtx.Lock()
 	defer t.mtx.Unlock()
+	// once the task has completed the events channel is about to be closed.
+	if t.completed || t.stopped {
+		return
+	}
 
 	t.events <- TaskEvent{
 		JobID:     t.job.ID,
@@ -362,6 +552,35 @@ func (t *Task) Files() []job.File {
 	return t.files
 }
 
+// Failures are the outputs which could not be made, the task still succeeds with the rest when the job allows partial results.
+func (t *Task) Failures() []job.Failure {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if !t.completed || t.failed != nil {
+		return nil
+	}
+
+	return t.failures
+}
+
+// Trim is the box the frames were trimmed to, it is nil unless trimming was requested and the task completed.
+func (t *Task) Trim() *image.Rect {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if !t.completed || t.failed != nil {
+		return nil
+	}
+
+	return t.trim
+}
+
+// Type is the detected type of the raw input, it is empty until the input has been downloaded and detected.
+func (t *Task) Type() image.ImageType {
+	return t.imgType
+}
+
 func (t *Task) Completed() bool {
 	return t.completed
 }
@@ -0,0 +1,38 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/seventv/ImageProcessor/src/containers"
+	"github.com/seventv/ImageProcessor/src/job"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_partialFailures(t *testing.T) {
+	ctx := context.Background()
+	files := []job.File{{Name: "1x.webp"}}
+
+	avifErr := &containers.OutputError{Format: "image/avif", Size: "4x", Name: "4x.avif", Err: fmt.Errorf("avifenc failed")}
+	err := multierror.Append(nil, avifErr, &containers.OutputError{Format: "image/gif", Size: "1x", Name: "1x.gif", Err: fmt.Errorf("gifski failed")})
+
+	failures, ok := partialFailures(ctx, err, files)
+	assert.True(t, ok, "Failed outputs are a partial success")
+	assert.Equal(t, []job.Failure{
+		{Format: "image/avif", Size: "4x", Name: "4x.avif", Error: "avifenc failed"},
+		{Format: "image/gif", Size: "1x", Name: "1x.gif", Error: "gifski failed"},
+	}, failures, "Every failed output is reported")
+
+	_, ok = partialFailures(ctx, avifErr, nil)
+	assert.False(t, ok, "It is not a partial success without any outputs")
+
+	_, ok = partialFailures(ctx, multierror.Append(nil, avifErr, fmt.Errorf("remove failed")), files)
+	assert.False(t, ok, "It is not a partial success when an error does not belong to an output")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, ok = partialFailures(cancelled, avifErr, files)
+	assert.False(t, ok, "It is not a partial success when the task was stopped")
+}